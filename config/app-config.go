@@ -0,0 +1,856 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting the application reads at startup.
+//
+// Values are resolved in this order, each source overriding the previous one:
+// built-in defaults, the config file (YAML or TOML), environment variables
+// and finally command line flags.
+type Config struct {
+	Server    ServerConfig    `yaml:"server" toml:"server"`
+	Database  DatabaseConfig  `yaml:"database" toml:"database"`
+	Auth      AuthConfig      `yaml:"auth" toml:"auth"`
+	Upload    UploadConfig    `yaml:"upload" toml:"upload"`
+	CORS      CORSConfig      `yaml:"cors" toml:"cors"`
+	Metrics   MetricsConfig   `yaml:"metrics" toml:"metrics"`
+	Log       LogConfig       `yaml:"log" toml:"log"`
+	Tracing   TracingConfig   `yaml:"tracing" toml:"tracing"`
+	RateLimit RateLimitConfig `yaml:"rate_limit" toml:"rate_limit"`
+	Mail      MailConfig      `yaml:"mail" toml:"mail"`
+	OIDC      OIDCConfig      `yaml:"oidc" toml:"oidc"`
+	Scan      ScanConfig      `yaml:"scan" toml:"scan"`
+}
+
+type ServerConfig struct {
+	Host            string        `yaml:"host" toml:"host"`
+	Port            int           `yaml:"port" toml:"port"`
+	ShutdownDelay   time.Duration `yaml:"shutdown_delay" toml:"shutdown_delay"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	// TrustedProxies are the CIDR ranges of reverse proxies whose
+	// X-Forwarded-For header is believed. Without any, the client IP is the
+	// address of the connection.
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+}
+
+type DatabaseConfig struct {
+	Host            string        `yaml:"host" toml:"host"`
+	Port            int           `yaml:"port" toml:"port"`
+	User            string        `yaml:"user" toml:"user"`
+	Password        string        `yaml:"password" toml:"password"`
+	Name            string        `yaml:"name" toml:"name"`
+	SSLMode         string        `yaml:"sslmode" toml:"sslmode"`
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" toml:"conn_max_idle_time"`
+}
+
+type AuthConfig struct {
+	JWTSecret      string        `yaml:"jwt_secret" toml:"jwt_secret"`
+	AccessTokenTTL time.Duration `yaml:"access_token_ttl" toml:"access_token_ttl"`
+	// TokenSecret keys the hashes of emailed tokens. Defaults to JWTSecret.
+	TokenSecret          string        `yaml:"token_secret" toml:"token_secret"`
+	RequireVerifiedEmail bool          `yaml:"require_verified_email" toml:"require_verified_email"`
+	EmailVerificationTTL time.Duration `yaml:"email_verification_ttl" toml:"email_verification_ttl"`
+	PasswordResetTTL     time.Duration `yaml:"password_reset_ttl" toml:"password_reset_ttl"`
+	// AccountDeletionGrace is how long a deleted account can still be
+	// restored by logging in before it is purged.
+	AccountDeletionGrace time.Duration `yaml:"account_deletion_grace" toml:"account_deletion_grace"`
+	TOTPIssuer           string        `yaml:"totp_issuer" toml:"totp_issuer"`
+	MFAChallengeTTL      time.Duration `yaml:"mfa_challenge_ttl" toml:"mfa_challenge_ttl"`
+	// JWTAlgorithm is EdDSA, RS256 or HS256. HS256 signs with JWTSecret;
+	// the others sign with generated keys published as a JWKS.
+	JWTAlgorithm string `yaml:"jwt_algorithm" toml:"jwt_algorithm"`
+	// KeyRotation is how long a signing key is used before a new one
+	// replaces it. Old keys stay valid until their tokens have expired.
+	KeyRotation time.Duration `yaml:"key_rotation" toml:"key_rotation"`
+	// JWTIssuer and JWTAudience are set in issued tokens and required when
+	// verifying them.
+	JWTIssuer   string `yaml:"jwt_issuer" toml:"jwt_issuer"`
+	JWTAudience string `yaml:"jwt_audience" toml:"jwt_audience"`
+	// AdminEmails are given the admin role at startup, which bootstraps
+	// the first administrators.
+	AdminEmails []string `yaml:"admin_emails" toml:"admin_emails"`
+}
+
+type UploadConfig struct {
+	// MaxImageSize is the size limit of the allowed types without an entry
+	// in MaxSizes.
+	MaxImageSize int64 `yaml:"max_image_size" toml:"max_image_size"`
+	// MaxSizes are size limits by content type.
+	MaxSizes     map[string]int64 `yaml:"max_sizes" toml:"max_sizes"`
+	AllowedTypes []string         `yaml:"allowed_types" toml:"allowed_types"`
+	// Variants are the named resized versions of every image, generated
+	// on first request.
+	Variants []ImageVariantConfig `yaml:"variants" toml:"variants"`
+	// MaxVariantDimension bounds the width and height clients can ask for
+	// with ?w= and ?h=.
+	MaxVariantDimension int `yaml:"max_variant_dimension" toml:"max_variant_dimension"`
+	// MaxImagePixels rejects images whose width times height exceeds it
+	// before they are decoded, so that small files cannot expand into
+	// huge bitmaps.
+	MaxImagePixels int64 `yaml:"max_image_pixels" toml:"max_image_pixels"`
+	// KeepMetadata stores the EXIF tags stripped from uploaded images.
+	KeepMetadata bool `yaml:"keep_metadata" toml:"keep_metadata"`
+	// QuotaBytes and QuotaFiles limit the attachments on the tasks of a
+	// user, unless an administrator has set a quota for them. Zero means
+	// unlimited.
+	QuotaBytes int64 `yaml:"quota_bytes" toml:"quota_bytes"`
+	QuotaFiles int64 `yaml:"quota_files" toml:"quota_files"`
+	// MaxBatchFiles and MaxBatchSize limit the number of files and the
+	// total size of a multi-file upload.
+	MaxBatchFiles int   `yaml:"max_batch_files" toml:"max_batch_files"`
+	MaxBatchSize  int64 `yaml:"max_batch_size" toml:"max_batch_size"`
+	// ChunkSize is the largest part of a resumable upload sent in one
+	// request.
+	ChunkSize int64 `yaml:"chunk_size" toml:"chunk_size"`
+	// ResumableExpiry is how long an unfinished resumable upload is kept.
+	ResumableExpiry time.Duration `yaml:"resumable_expiry" toml:"resumable_expiry"`
+}
+
+// ImageVariantConfig fits images within Width x Height, keeping the aspect
+// ratio.
+type ImageVariantConfig struct {
+	Name   string `yaml:"name" toml:"name"`
+	Width  int    `yaml:"width" toml:"width"`
+	Height int    `yaml:"height" toml:"height"`
+}
+
+// MaxSize returns the size limit of attachments of contentType.
+func (u UploadConfig) MaxSize(contentType string) int64 {
+	if size, ok := u.MaxSizes[contentType]; ok {
+		return size
+	}
+	return u.MaxImageSize
+}
+
+// LargestSize returns the largest size limit of any allowed type.
+func (u UploadConfig) LargestSize() int64 {
+	largest := int64(0)
+	for _, contentType := range u.AllowedTypes {
+		largest = max(largest, u.MaxSize(contentType))
+	}
+	return largest
+}
+
+// Variant returns the variant called name.
+func (u UploadConfig) Variant(name string) (ImageVariantConfig, bool) {
+	for _, v := range u.Variants {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return ImageVariantConfig{}, false
+}
+
+type CORSConfig struct {
+	Enabled          bool     `yaml:"enabled" toml:"enabled"`
+	AllowOrigins     []string `yaml:"allow_origins" toml:"allow_origins"`
+	AllowMethods     []string `yaml:"allow_methods" toml:"allow_methods"`
+	AllowHeaders     []string `yaml:"allow_headers" toml:"allow_headers"`
+	AllowCredentials bool     `yaml:"allow_credentials" toml:"allow_credentials"`
+	MaxAge           int      `yaml:"max_age" toml:"max_age"`
+}
+
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled" toml:"enabled"`
+	Path    string `yaml:"path" toml:"path"`
+}
+
+type LogConfig struct {
+	Level              string        `yaml:"level" toml:"level"`
+	Format             string        `yaml:"format" toml:"format"`
+	SlowQueryThreshold time.Duration `yaml:"slow_query_threshold" toml:"slow_query_threshold"`
+}
+
+type TracingConfig struct {
+	Enabled     bool    `yaml:"enabled" toml:"enabled"`
+	ServiceName string  `yaml:"service_name" toml:"service_name"`
+	Exporter    string  `yaml:"exporter" toml:"exporter"`
+	Protocol    string  `yaml:"protocol" toml:"protocol"`
+	Endpoint    string  `yaml:"endpoint" toml:"endpoint"`
+	Insecure    bool    `yaml:"insecure" toml:"insecure"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"`
+}
+
+type RateLimitConfig struct {
+	Enabled               bool               `yaml:"enabled" toml:"enabled"`
+	Backend               string             `yaml:"backend" toml:"backend"`
+	RedisAddr             string             `yaml:"redis_addr" toml:"redis_addr"`
+	RedisPassword         string             `yaml:"redis_password" toml:"redis_password"`
+	RedisDB               int                `yaml:"redis_db" toml:"redis_db"`
+	IPRequestsPerMinute   int                `yaml:"ip_requests_per_minute" toml:"ip_requests_per_minute"`
+	IPBurst               int                `yaml:"ip_burst" toml:"ip_burst"`
+	UserRequestsPerMinute int                `yaml:"user_requests_per_minute" toml:"user_requests_per_minute"`
+	UserBurst             int                `yaml:"user_burst" toml:"user_burst"`
+	Login                 LoginLockoutConfig `yaml:"login" toml:"login"`
+}
+
+// LoginLockoutConfig controls the progressive lockout after failed logins.
+// Failures are counted per email and per IP; the IP threshold is higher so a
+// shared address is not locked out by a single user.
+type LoginLockoutConfig struct {
+	MaxFailures   int           `yaml:"max_failures" toml:"max_failures"`
+	IPMaxFailures int           `yaml:"ip_max_failures" toml:"ip_max_failures"`
+	FailureWindow time.Duration `yaml:"failure_window" toml:"failure_window"`
+	Lockout       time.Duration `yaml:"lockout" toml:"lockout"`
+	MaxLockout    time.Duration `yaml:"max_lockout" toml:"max_lockout"`
+}
+
+type MailConfig struct {
+	// Driver is "smtp", "file" or "log". The log driver leaves out the
+	// body, so links in emails can only be followed with the others.
+	Driver       string `yaml:"driver" toml:"driver"`
+	From         string `yaml:"from" toml:"from"`
+	SMTPHost     string `yaml:"smtp_host" toml:"smtp_host"`
+	SMTPPort     int    `yaml:"smtp_port" toml:"smtp_port"`
+	SMTPUsername string `yaml:"smtp_username" toml:"smtp_username"`
+	SMTPPassword string `yaml:"smtp_password" toml:"smtp_password"`
+	Dir          string `yaml:"dir" toml:"dir"`
+	// LinkBaseURL is the frontend URL that links in emails point to. Public
+	// task links are also built on it.
+	LinkBaseURL string `yaml:"link_base_url" toml:"link_base_url"`
+	// Timeout bounds sending one email, including looking up the account
+	// it goes to.
+	Timeout time.Duration `yaml:"timeout" toml:"timeout"`
+}
+
+type ScanConfig struct {
+	// Driver is "clamav", "eicar" or "none". The eicar driver only flags
+	// the EICAR test file and is meant for testing.
+	Driver string `yaml:"driver" toml:"driver"`
+	// ClamAVNetwork is "unix" or "tcp", and ClamAVAddress the socket path
+	// or host:port of clamd.
+	ClamAVNetwork string        `yaml:"clamav_network" toml:"clamav_network"`
+	ClamAVAddress string        `yaml:"clamav_address" toml:"clamav_address"`
+	Timeout       time.Duration `yaml:"timeout" toml:"timeout"`
+}
+
+// OIDCConfig lists the external identity providers users can log in with.
+// Providers can only be configured in the config file.
+type OIDCConfig struct {
+	Providers []OIDCProviderConfig `yaml:"providers" toml:"providers"`
+}
+
+type OIDCProviderConfig struct {
+	// Name identifies the provider in the login URL.
+	Name         string   `yaml:"name" toml:"name"`
+	IssuerURL    string   `yaml:"issuer_url" toml:"issuer_url"`
+	ClientID     string   `yaml:"client_id" toml:"client_id"`
+	ClientSecret string   `yaml:"client_secret" toml:"client_secret"`
+	RedirectURL  string   `yaml:"redirect_url" toml:"redirect_url"`
+	Scopes       []string `yaml:"scopes" toml:"scopes"`
+	// AllowSignup creates an account on first login when no account with
+	// the verified email exists.
+	AllowSignup bool `yaml:"allow_signup" toml:"allow_signup"`
+	// FrontendRedirectURL, when set, receives the login result in the URL
+	// fragment instead of the callback answering with JSON.
+	FrontendRedirectURL string `yaml:"frontend_redirect_url" toml:"frontend_redirect_url"`
+}
+
+// Provider returns the provider called name.
+func (o OIDCConfig) Provider(name string) (OIDCProviderConfig, bool) {
+	for _, p := range o.Providers {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return OIDCProviderConfig{}, false
+}
+
+// Cfg is the configuration loaded at startup.
+var Cfg *Config
+
+// Default returns the configuration used when no other source sets a value.
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:            8000,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Database: DatabaseConfig{
+			Host:            "localhost",
+			Port:            5432,
+			SSLMode:         "disable",
+			MaxOpenConns:    25,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+		},
+		Auth: AuthConfig{
+			AccessTokenTTL:       7 * 24 * time.Hour,
+			EmailVerificationTTL: 24 * time.Hour,
+			PasswordResetTTL:     time.Hour,
+			AccountDeletionGrace: 30 * 24 * time.Hour,
+			TOTPIssuer:           "Task Management API",
+			MFAChallengeTTL:      5 * time.Minute,
+			JWTAlgorithm:         "EdDSA",
+			KeyRotation:          30 * 24 * time.Hour,
+			JWTIssuer:            "todo-app",
+			JWTAudience:          "todo-app",
+		},
+		Upload: UploadConfig{
+			MaxImageSize: 10 * 1024 * 1024,
+			MaxSizes: map[string]int64{
+				"application/pdf": 25 * 1024 * 1024,
+				"application/zip": 50 * 1024 * 1024,
+			},
+			AllowedTypes: []string{"image/jpeg", "image/png", "image/gif", "image/webp", "application/pdf", "text/plain", "application/zip"},
+			Variants: []ImageVariantConfig{
+				{Name: "thumb", Width: 200, Height: 200},
+				{Name: "medium", Width: 800, Height: 800},
+			},
+			MaxVariantDimension: 2048,
+			MaxImagePixels:      50_000_000,
+			QuotaBytes:          1024 * 1024 * 1024,
+			QuotaFiles:          10_000,
+			MaxBatchFiles:       20,
+			MaxBatchSize:        100 * 1024 * 1024,
+			ChunkSize:           5 * 1024 * 1024,
+			ResumableExpiry:     24 * time.Hour,
+		},
+		CORS: CORSConfig{
+			AllowOrigins: []string{"*"},
+			AllowMethods: []string{"GET", "POST", "PATCH", "DELETE", "OPTIONS"},
+			AllowHeaders: []string{"Authorization", "Content-Type"},
+			MaxAge:       600,
+		},
+		Metrics: MetricsConfig{
+			Enabled: true,
+			Path:    "/metrics",
+		},
+		Log: LogConfig{
+			Level:              "info",
+			Format:             "json",
+			SlowQueryThreshold: 200 * time.Millisecond,
+		},
+		Tracing: TracingConfig{
+			ServiceName: "todo-app",
+			Exporter:    "otlp",
+			Protocol:    "grpc",
+			SampleRatio: 1,
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:               true,
+			Backend:               "memory",
+			RedisAddr:             "localhost:6379",
+			IPRequestsPerMinute:   300,
+			IPBurst:               60,
+			UserRequestsPerMinute: 120,
+			UserBurst:             30,
+			Login: LoginLockoutConfig{
+				MaxFailures:   5,
+				IPMaxFailures: 20,
+				FailureWindow: 15 * time.Minute,
+				Lockout:       time.Minute,
+				MaxLockout:    time.Hour,
+			},
+		},
+		Scan: ScanConfig{
+			Driver:        "none",
+			ClamAVNetwork: "unix",
+			ClamAVAddress: "/var/run/clamav/clamd.ctl",
+			Timeout:       30 * time.Second,
+		},
+		Mail: MailConfig{
+			Driver:      "file",
+			From:        "no-reply@localhost",
+			SMTPPort:    587,
+			Dir:         "mail",
+			LinkBaseURL: "http://localhost:8000",
+			Timeout:     30 * time.Second,
+		},
+	}
+}
+
+// Addr returns the address the HTTP server listens on.
+func (s ServerConfig) Addr() string {
+	return s.Host + ":" + strconv.Itoa(s.Port)
+}
+
+// SlogLevel returns the configured level, defaulting to info.
+func (l LogConfig) SlogLevel() slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(l.Level)); err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+// DSN returns the PostgreSQL connection string.
+func (d DatabaseConfig) DSN() string {
+	return "host=" + dsnValue(d.Host) + " user=" + dsnValue(d.User) + " password=" + dsnValue(d.Password) + " dbname=" + dsnValue(d.Name) + " port=" + strconv.Itoa(d.Port) + " sslmode=" + dsnValue(d.SSLMode)
+}
+
+// dsnQuoter escapes the characters that end a quoted connection string value.
+var dsnQuoter = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// dsnValue quotes a connection string value, so that a password containing
+// spaces or quotes is not read as further keywords.
+func dsnValue(value string) string {
+	return "'" + dsnQuoter.Replace(value) + "'"
+}
+
+// setting binds one config value to its environment variable and flag.
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{"SERVER_HOST", "host", "address to listen on", str(func(c *Config) *string { return &c.Server.Host })},
+	{"SERVER_PORT", "port", "port to listen on", integer(func(c *Config) *int { return &c.Server.Port })},
+	{"SHUTDOWN_DELAY", "shutdown-delay", "time to keep serving with failing readiness before shutting down", duration(func(c *Config) *time.Duration { return &c.Server.ShutdownDelay })},
+	{"SHUTDOWN_TIMEOUT", "shutdown-timeout", "time to wait for in-flight requests to finish on shutdown", duration(func(c *Config) *time.Duration { return &c.Server.ShutdownTimeout })},
+	{"TRUSTED_PROXIES", "trusted-proxies", "comma separated CIDR ranges of reverse proxies trusted for X-Forwarded-For", list(func(c *Config) *[]string { return &c.Server.TrustedProxies })},
+
+	{"DB_HOST", "db-host", "database host", str(func(c *Config) *string { return &c.Database.Host })},
+	{"DB_PORT", "db-port", "database port", integer(func(c *Config) *int { return &c.Database.Port })},
+	{"DB_USER", "db-user", "database user", str(func(c *Config) *string { return &c.Database.User })},
+	{"DB_PASSWORD", "db-password", "database password", str(func(c *Config) *string { return &c.Database.Password })},
+	{"DB_NAME", "db-name", "database name", str(func(c *Config) *string { return &c.Database.Name })},
+	{"DB_SSLMODE", "db-sslmode", "database sslmode", str(func(c *Config) *string { return &c.Database.SSLMode })},
+	{"DB_MAX_OPEN_CONNS", "db-max-open-conns", "maximum open database connections", integer(func(c *Config) *int { return &c.Database.MaxOpenConns })},
+	{"DB_MAX_IDLE_CONNS", "db-max-idle-conns", "maximum idle database connections", integer(func(c *Config) *int { return &c.Database.MaxIdleConns })},
+	{"DB_CONN_MAX_LIFETIME", "db-conn-max-lifetime", "maximum lifetime of a database connection", duration(func(c *Config) *time.Duration { return &c.Database.ConnMaxLifetime })},
+	{"DB_CONN_MAX_IDLE_TIME", "db-conn-max-idle-time", "maximum idle time of a database connection", duration(func(c *Config) *time.Duration { return &c.Database.ConnMaxIdleTime })},
+
+	{"JWT_SECRET", "jwt-secret", "secret key for signing JWT tokens", str(func(c *Config) *string { return &c.Auth.JWTSecret })},
+	{"ACCESS_TOKEN_TTL", "access-token-ttl", "lifetime of issued access tokens", duration(func(c *Config) *time.Duration { return &c.Auth.AccessTokenTTL })},
+	{"TOKEN_SECRET", "token-secret", "secret key for hashing emailed tokens, defaults to the JWT secret", str(func(c *Config) *string { return &c.Auth.TokenSecret })},
+	{"REQUIRE_VERIFIED_EMAIL", "require-verified-email", "block login until the email address is verified", boolean(func(c *Config) *bool { return &c.Auth.RequireVerifiedEmail })},
+	{"EMAIL_VERIFICATION_TTL", "email-verification-ttl", "lifetime of email verification links", duration(func(c *Config) *time.Duration { return &c.Auth.EmailVerificationTTL })},
+	{"PASSWORD_RESET_TTL", "password-reset-ttl", "lifetime of password reset links", duration(func(c *Config) *time.Duration { return &c.Auth.PasswordResetTTL })},
+	{"ACCOUNT_DELETION_GRACE", "account-deletion-grace", "time before a deleted account is purged", duration(func(c *Config) *time.Duration { return &c.Auth.AccountDeletionGrace })},
+	{"TOTP_ISSUER", "totp-issuer", "issuer shown in authenticator apps", str(func(c *Config) *string { return &c.Auth.TOTPIssuer })},
+	{"MFA_CHALLENGE_TTL", "mfa-challenge-ttl", "time to enter the second factor after the password", duration(func(c *Config) *time.Duration { return &c.Auth.MFAChallengeTTL })},
+	{"JWT_ALGORITHM", "jwt-algorithm", "access token signing algorithm: EdDSA, RS256 or HS256", str(func(c *Config) *string { return &c.Auth.JWTAlgorithm })},
+	{"JWT_ISSUER", "jwt-issuer", "issuer of access tokens", str(func(c *Config) *string { return &c.Auth.JWTIssuer })},
+	{"JWT_AUDIENCE", "jwt-audience", "audience of access tokens", str(func(c *Config) *string { return &c.Auth.JWTAudience })},
+	{"ADMIN_EMAILS", "admin-emails", "comma separated emails of users given the admin role at startup", list(func(c *Config) *[]string { return &c.Auth.AdminEmails })},
+	{"JWT_KEY_ROTATION", "jwt-key-rotation", "how long a signing key is used before it is rotated", duration(func(c *Config) *time.Duration { return &c.Auth.KeyRotation })},
+
+	{"UPLOAD_MAX_IMAGE_SIZE", "upload-max-image-size", "maximum attachment size in bytes for types without their own limit", integer64(func(c *Config) *int64 { return &c.Upload.MaxImageSize })},
+	{"UPLOAD_MAX_SIZES", "upload-max-sizes", "comma separated size limits in bytes as content/type=bytes", sizes(func(c *Config) *map[string]int64 { return &c.Upload.MaxSizes })},
+	{"UPLOAD_ALLOWED_TYPES", "upload-allowed-types", "comma separated list of accepted attachment content types", list(func(c *Config) *[]string { return &c.Upload.AllowedTypes })},
+	{"UPLOAD_IMAGE_VARIANTS", "upload-image-variants", "comma separated image variants as name=WIDTHxHEIGHT", variants(func(c *Config) *[]ImageVariantConfig { return &c.Upload.Variants })},
+	{"UPLOAD_MAX_VARIANT_DIMENSION", "upload-max-variant-dimension", "largest width or height clients can request for an image", integer(func(c *Config) *int { return &c.Upload.MaxVariantDimension })},
+	{"UPLOAD_MAX_IMAGE_PIXELS", "upload-max-image-pixels", "largest width times height of accepted images", integer64(func(c *Config) *int64 { return &c.Upload.MaxImagePixels })},
+	{"UPLOAD_QUOTA_BYTES", "upload-quota-bytes", "default storage quota per user in bytes, 0 for unlimited", integer64(func(c *Config) *int64 { return &c.Upload.QuotaBytes })},
+	{"UPLOAD_QUOTA_FILES", "upload-quota-files", "default number of attachments per user, 0 for unlimited", integer64(func(c *Config) *int64 { return &c.Upload.QuotaFiles })},
+	{"UPLOAD_MAX_BATCH_FILES", "upload-max-batch-files", "maximum number of files in a multi-file upload", integer(func(c *Config) *int { return &c.Upload.MaxBatchFiles })},
+	{"UPLOAD_MAX_BATCH_SIZE", "upload-max-batch-size", "maximum total size in bytes of a multi-file upload", integer64(func(c *Config) *int64 { return &c.Upload.MaxBatchSize })},
+	{"UPLOAD_CHUNK_SIZE", "upload-chunk-size", "maximum size in bytes of a resumable upload chunk", integer64(func(c *Config) *int64 { return &c.Upload.ChunkSize })},
+	{"UPLOAD_RESUMABLE_EXPIRY", "upload-resumable-expiry", "time after which unfinished resumable uploads are deleted", duration(func(c *Config) *time.Duration { return &c.Upload.ResumableExpiry })},
+	{"UPLOAD_KEEP_METADATA", "upload-keep-metadata", "store the EXIF metadata stripped from uploaded images", boolean(func(c *Config) *bool { return &c.Upload.KeepMetadata })},
+
+	{"CORS_ENABLED", "cors-enabled", "enable CORS headers", boolean(func(c *Config) *bool { return &c.CORS.Enabled })},
+	{"CORS_ALLOW_ORIGINS", "cors-allow-origins", "comma separated list of allowed origins", list(func(c *Config) *[]string { return &c.CORS.AllowOrigins })},
+	{"CORS_ALLOW_METHODS", "cors-allow-methods", "comma separated list of allowed methods", list(func(c *Config) *[]string { return &c.CORS.AllowMethods })},
+	{"CORS_ALLOW_HEADERS", "cors-allow-headers", "comma separated list of allowed headers", list(func(c *Config) *[]string { return &c.CORS.AllowHeaders })},
+	{"CORS_ALLOW_CREDENTIALS", "cors-allow-credentials", "allow credentials in CORS requests", boolean(func(c *Config) *bool { return &c.CORS.AllowCredentials })},
+	{"CORS_MAX_AGE", "cors-max-age", "seconds a preflight response may be cached", integer(func(c *Config) *int { return &c.CORS.MaxAge })},
+
+	{"METRICS_ENABLED", "metrics-enabled", "expose Prometheus metrics", boolean(func(c *Config) *bool { return &c.Metrics.Enabled })},
+	{"METRICS_PATH", "metrics-path", "path of the Prometheus metrics endpoint", str(func(c *Config) *string { return &c.Metrics.Path })},
+
+	{"LOG_LEVEL", "log-level", "log level: debug, info, warn or error", str(func(c *Config) *string { return &c.Log.Level })},
+	{"LOG_FORMAT", "log-format", "log format: json or text", str(func(c *Config) *string { return &c.Log.Format })},
+	{"LOG_SLOW_QUERY_THRESHOLD", "log-slow-query-threshold", "database queries slower than this are logged as warnings", duration(func(c *Config) *time.Duration { return &c.Log.SlowQueryThreshold })},
+
+	{"TRACING_ENABLED", "tracing-enabled", "enable OpenTelemetry tracing", boolean(func(c *Config) *bool { return &c.Tracing.Enabled })},
+	{"TRACING_SERVICE_NAME", "tracing-service-name", "service name reported in traces", str(func(c *Config) *string { return &c.Tracing.ServiceName })},
+	{"TRACING_EXPORTER", "tracing-exporter", "trace exporter: otlp or stdout", str(func(c *Config) *string { return &c.Tracing.Exporter })},
+	{"TRACING_PROTOCOL", "tracing-protocol", "OTLP protocol: grpc or http", str(func(c *Config) *string { return &c.Tracing.Protocol })},
+	{"TRACING_ENDPOINT", "tracing-endpoint", "OTLP collector endpoint, e.g. localhost:4317", str(func(c *Config) *string { return &c.Tracing.Endpoint })},
+	{"TRACING_INSECURE", "tracing-insecure", "disable TLS for the OTLP exporter", boolean(func(c *Config) *bool { return &c.Tracing.Insecure })},
+	{"TRACING_SAMPLE_RATIO", "tracing-sample-ratio", "fraction of new traces to sample, between 0 and 1", float(func(c *Config) *float64 { return &c.Tracing.SampleRatio })},
+
+	{"MAIL_DRIVER", "mail-driver", "mail delivery: smtp, file or log", str(func(c *Config) *string { return &c.Mail.Driver })},
+	{"MAIL_FROM", "mail-from", "sender address of outgoing emails", str(func(c *Config) *string { return &c.Mail.From })},
+	{"SMTP_HOST", "smtp-host", "SMTP server host", str(func(c *Config) *string { return &c.Mail.SMTPHost })},
+	{"SMTP_PORT", "smtp-port", "SMTP server port", integer(func(c *Config) *int { return &c.Mail.SMTPPort })},
+	{"SMTP_USERNAME", "smtp-username", "SMTP username", str(func(c *Config) *string { return &c.Mail.SMTPUsername })},
+	{"SMTP_PASSWORD", "smtp-password", "SMTP password", str(func(c *Config) *string { return &c.Mail.SMTPPassword })},
+	{"MAIL_DIR", "mail-dir", "directory the file mail driver writes to", str(func(c *Config) *string { return &c.Mail.Dir })},
+	{"MAIL_LINK_BASE_URL", "mail-link-base-url", "frontend URL that links in emails point to", str(func(c *Config) *string { return &c.Mail.LinkBaseURL })},
+	{"MAIL_TIMEOUT", "mail-timeout", "time limit for sending one email", duration(func(c *Config) *time.Duration { return &c.Mail.Timeout })},
+	{"SCAN_DRIVER", "scan-driver", "malware scanner for attachments: clamav, eicar or none", str(func(c *Config) *string { return &c.Scan.Driver })},
+	{"CLAMAV_NETWORK", "clamav-network", "network of the clamd socket: unix or tcp", str(func(c *Config) *string { return &c.Scan.ClamAVNetwork })},
+	{"CLAMAV_ADDRESS", "clamav-address", "clamd socket path or host:port", str(func(c *Config) *string { return &c.Scan.ClamAVAddress })},
+	{"SCAN_TIMEOUT", "scan-timeout", "time limit for scanning one attachment", duration(func(c *Config) *time.Duration { return &c.Scan.Timeout })},
+
+	{"RATE_LIMIT_ENABLED", "rate-limit-enabled", "enable rate limiting", boolean(func(c *Config) *bool { return &c.RateLimit.Enabled })},
+	{"RATE_LIMIT_BACKEND", "rate-limit-backend", "rate limit store: memory or redis", str(func(c *Config) *string { return &c.RateLimit.Backend })},
+	{"RATE_LIMIT_REDIS_ADDR", "rate-limit-redis-addr", "address of the Redis compatible server", str(func(c *Config) *string { return &c.RateLimit.RedisAddr })},
+	{"RATE_LIMIT_REDIS_PASSWORD", "rate-limit-redis-password", "password of the Redis compatible server", str(func(c *Config) *string { return &c.RateLimit.RedisPassword })},
+	{"RATE_LIMIT_REDIS_DB", "rate-limit-redis-db", "Redis database number", integer(func(c *Config) *int { return &c.RateLimit.RedisDB })},
+	{"RATE_LIMIT_IP_PER_MINUTE", "rate-limit-ip-per-minute", "requests per minute allowed per IP", integer(func(c *Config) *int { return &c.RateLimit.IPRequestsPerMinute })},
+	{"RATE_LIMIT_IP_BURST", "rate-limit-ip-burst", "burst of requests allowed per IP", integer(func(c *Config) *int { return &c.RateLimit.IPBurst })},
+	{"RATE_LIMIT_USER_PER_MINUTE", "rate-limit-user-per-minute", "requests per minute allowed per user", integer(func(c *Config) *int { return &c.RateLimit.UserRequestsPerMinute })},
+	{"RATE_LIMIT_USER_BURST", "rate-limit-user-burst", "burst of requests allowed per user", integer(func(c *Config) *int { return &c.RateLimit.UserBurst })},
+	{"LOGIN_MAX_FAILURES", "login-max-failures", "failed logins per email before lockout", integer(func(c *Config) *int { return &c.RateLimit.Login.MaxFailures })},
+	{"LOGIN_IP_MAX_FAILURES", "login-ip-max-failures", "failed logins per IP before lockout", integer(func(c *Config) *int { return &c.RateLimit.Login.IPMaxFailures })},
+	{"LOGIN_FAILURE_WINDOW", "login-failure-window", "window in which failed logins are counted", duration(func(c *Config) *time.Duration { return &c.RateLimit.Login.FailureWindow })},
+	{"LOGIN_LOCKOUT", "login-lockout", "first lockout duration, doubled on every further failure", duration(func(c *Config) *time.Duration { return &c.RateLimit.Login.Lockout })},
+	{"LOGIN_MAX_LOCKOUT", "login-max-lockout", "maximum lockout duration", duration(func(c *Config) *time.Duration { return &c.RateLimit.Login.MaxLockout })},
+}
+
+// Load builds the configuration from defaults, the config file, the
+// environment and the given command line arguments, then validates it.
+//
+// The config file is taken from the -config flag or the CONFIG_FILE
+// environment variable. Its format is picked from the extension.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("todo-app", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	values := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		values[s.flag] = fs.String(s.flag, "", s.usage+" (env "+s.env+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		value, ok := os.LookupEnv(s.env)
+		if !ok {
+			continue
+		}
+		if err := s.set(cfg, value); err != nil {
+			return nil, fmt.Errorf("env %s: %w", s.env, err)
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag != f.Name || flagErr != nil {
+				continue
+			}
+			if err := s.set(cfg, *values[s.flag]); err != nil {
+				flagErr = fmt.Errorf("flag -%s: %w", s.flag, err)
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if cfg.Auth.TokenSecret == "" {
+		cfg.Auth.TokenSecret = cfg.Auth.JWTSecret
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	case ".toml":
+		_, err = toml.Decode(string(data), c)
+	default:
+		return fmt.Errorf("config file %s: unsupported format, use .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Port > 0 && c.Server.Port <= 65535, "server.port must be between 1 and 65535, got %d", c.Server.Port)
+	check(c.Server.ShutdownDelay >= 0, "server.shutdown_delay must not be negative")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	for _, cidr := range c.Server.TrustedProxies {
+		_, _, err := net.ParseCIDR(cidr)
+		check(err == nil, "server.trusted_proxies must be CIDR ranges, got %q", cidr)
+	}
+
+	check(c.Database.Host != "", "database.host is required (env DB_HOST)")
+	check(c.Database.Port > 0 && c.Database.Port <= 65535, "database.port must be between 1 and 65535, got %d", c.Database.Port)
+	check(c.Database.User != "", "database.user is required (env DB_USER)")
+	check(c.Database.Name != "", "database.name is required (env DB_NAME)")
+	check(c.Database.MaxOpenConns >= 0, "database.max_open_conns must not be negative")
+	check(c.Database.MaxIdleConns >= 0, "database.max_idle_conns must not be negative")
+	check(c.Database.MaxOpenConns == 0 || c.Database.MaxIdleConns <= c.Database.MaxOpenConns,
+		"database.max_idle_conns (%d) must not exceed database.max_open_conns (%d)", c.Database.MaxIdleConns, c.Database.MaxOpenConns)
+	check(c.Database.ConnMaxLifetime >= 0, "database.conn_max_lifetime must not be negative")
+	check(c.Database.ConnMaxIdleTime >= 0, "database.conn_max_idle_time must not be negative")
+
+	check(c.Auth.JWTSecret != "", "auth.jwt_secret is required (env JWT_SECRET)")
+	check(c.Auth.JWTAlgorithm == "EdDSA" || c.Auth.JWTAlgorithm == "RS256" || c.Auth.JWTAlgorithm == "HS256",
+		"auth.jwt_algorithm must be EdDSA, RS256 or HS256, got %q", c.Auth.JWTAlgorithm)
+	check(c.Auth.KeyRotation > 0, "auth.key_rotation must be positive")
+	check(c.Auth.JWTIssuer != "", "auth.jwt_issuer must not be empty")
+	check(c.Auth.JWTAudience != "", "auth.jwt_audience must not be empty")
+	check(c.Auth.AccessTokenTTL > 0, "auth.access_token_ttl must be positive")
+	check(c.Auth.TokenSecret != "", "auth.token_secret is required (env TOKEN_SECRET)")
+	check(c.Auth.EmailVerificationTTL > 0, "auth.email_verification_ttl must be positive")
+	check(c.Auth.PasswordResetTTL > 0, "auth.password_reset_ttl must be positive")
+	check(c.Auth.AccountDeletionGrace >= 0, "auth.account_deletion_grace must not be negative")
+	check(c.Auth.TOTPIssuer != "", "auth.totp_issuer must not be empty")
+	check(c.Auth.MFAChallengeTTL > 0, "auth.mfa_challenge_ttl must be positive")
+
+	switch c.Mail.Driver {
+	case "smtp":
+		check(c.Mail.SMTPHost != "", "mail.smtp_host is required for the smtp driver (env SMTP_HOST)")
+		check(c.Mail.SMTPPort > 0 && c.Mail.SMTPPort <= 65535, "mail.smtp_port must be between 1 and 65535, got %d", c.Mail.SMTPPort)
+	case "file":
+		check(c.Mail.Dir != "", "mail.dir is required for the file driver (env MAIL_DIR)")
+	case "log":
+	default:
+		errs = append(errs, fmt.Errorf("mail.driver must be smtp, file or log, got %q", c.Mail.Driver))
+	}
+	check(c.Mail.From != "", "mail.from must not be empty")
+	check(c.Mail.LinkBaseURL != "", "mail.link_base_url must not be empty")
+	check(c.Mail.Timeout > 0, "mail.timeout must be positive")
+
+	switch c.Scan.Driver {
+	case "clamav":
+		check(c.Scan.ClamAVNetwork == "unix" || c.Scan.ClamAVNetwork == "tcp", "scan.clamav_network must be unix or tcp, got %q", c.Scan.ClamAVNetwork)
+		check(c.Scan.ClamAVAddress != "", "scan.clamav_address is required for the clamav driver (env CLAMAV_ADDRESS)")
+	case "eicar", "none":
+	default:
+		errs = append(errs, fmt.Errorf("scan.driver must be clamav, eicar or none, got %q", c.Scan.Driver))
+	}
+	check(c.Scan.Timeout > 0, "scan.timeout must be positive")
+
+	check(c.Upload.MaxImageSize > 0, "upload.max_image_size must be positive")
+	check(len(c.Upload.AllowedTypes) > 0, "upload.allowed_types must not be empty")
+	for contentType, size := range c.Upload.MaxSizes {
+		check(size > 0, "upload.max_sizes[%s] must be positive", contentType)
+	}
+	check(c.Upload.MaxVariantDimension > 0, "upload.max_variant_dimension must be positive")
+	check(c.Upload.MaxImagePixels > 0, "upload.max_image_pixels must be positive")
+	check(c.Upload.QuotaBytes >= 0, "upload.quota_bytes must not be negative")
+	check(c.Upload.QuotaFiles >= 0, "upload.quota_files must not be negative")
+	check(c.Upload.MaxBatchFiles > 0, "upload.max_batch_files must be positive")
+	check(c.Upload.MaxBatchSize > 0, "upload.max_batch_size must be positive")
+	check(c.Upload.ChunkSize > 0, "upload.chunk_size must be positive")
+	check(c.Upload.ResumableExpiry > 0, "upload.resumable_expiry must be positive")
+	variantNames := map[string]bool{}
+	for i, v := range c.Upload.Variants {
+		check(v.Name != "", "upload.variants[%d].name is required", i)
+		check(!variantNames[v.Name], "upload.variants[%d].name %q is used twice", i, v.Name)
+		check(v.Width > 0 && v.Height > 0, "upload.variants[%d] must have a positive width and height", i)
+		variantNames[v.Name] = true
+	}
+
+	if c.CORS.Enabled {
+		check(len(c.CORS.AllowOrigins) > 0, "cors.allow_origins must not be empty when CORS is enabled")
+		check(!c.CORS.AllowCredentials || !contains(c.CORS.AllowOrigins, "*"), "cors.allow_credentials cannot be used with a wildcard origin")
+		check(c.CORS.MaxAge >= 0, "cors.max_age must not be negative")
+	}
+
+	if c.Metrics.Enabled {
+		check(strings.HasPrefix(c.Metrics.Path, "/"), "metrics.path must start with /, got %q", c.Metrics.Path)
+	}
+
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Log.Level)) == nil, "log.level must be one of debug, info, warn or error, got %q", c.Log.Level)
+	check(c.Log.Format == "json" || c.Log.Format == "text", "log.format must be json or text, got %q", c.Log.Format)
+	check(c.Log.SlowQueryThreshold >= 0, "log.slow_query_threshold must not be negative")
+
+	if c.Tracing.Enabled {
+		check(c.Tracing.ServiceName != "", "tracing.service_name must not be empty")
+		check(c.Tracing.Exporter == "otlp" || c.Tracing.Exporter == "stdout", "tracing.exporter must be otlp or stdout, got %q", c.Tracing.Exporter)
+		check(c.Tracing.Protocol == "grpc" || c.Tracing.Protocol == "http", "tracing.protocol must be grpc or http, got %q", c.Tracing.Protocol)
+		check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1, got %v", c.Tracing.SampleRatio)
+	}
+
+	if c.RateLimit.Enabled {
+		check(c.RateLimit.Backend == "memory" || c.RateLimit.Backend == "redis", "rate_limit.backend must be memory or redis, got %q", c.RateLimit.Backend)
+		check(c.RateLimit.Backend != "redis" || c.RateLimit.RedisAddr != "", "rate_limit.redis_addr is required for the redis backend")
+		check(c.RateLimit.IPRequestsPerMinute > 0 && c.RateLimit.IPBurst > 0, "rate_limit.ip_requests_per_minute and rate_limit.ip_burst must be positive")
+		check(c.RateLimit.UserRequestsPerMinute > 0 && c.RateLimit.UserBurst > 0, "rate_limit.user_requests_per_minute and rate_limit.user_burst must be positive")
+	}
+	check(c.RateLimit.Login.MaxFailures > 0 && c.RateLimit.Login.IPMaxFailures > 0, "rate_limit.login.max_failures and rate_limit.login.ip_max_failures must be positive")
+	check(c.RateLimit.Login.FailureWindow > 0, "rate_limit.login.failure_window must be positive")
+	check(c.RateLimit.Login.Lockout > 0, "rate_limit.login.lockout must be positive")
+	check(c.RateLimit.Login.MaxLockout >= c.RateLimit.Login.Lockout, "rate_limit.login.max_lockout must not be shorter than rate_limit.login.lockout")
+
+	names := map[string]bool{}
+	for i, p := range c.OIDC.Providers {
+		check(p.Name != "", "oidc.providers[%d].name is required", i)
+		check(!names[p.Name], "oidc.providers[%d].name %q is used twice", i, p.Name)
+		check(p.IssuerURL != "", "oidc.providers[%d].issuer_url is required", i)
+		check(p.ClientID != "", "oidc.providers[%d].client_id is required", i)
+		check(p.RedirectURL != "", "oidc.providers[%d].redirect_url is required", i)
+		names[p.Name] = true
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func str(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func integer(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a valid integer", value)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func integer64(field func(*Config) *int64) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a valid integer", value)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func float(field func(*Config) *float64) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a valid number", value)
+		}
+		*field(c) = f
+		return nil
+	}
+}
+
+func boolean(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a valid boolean", value)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func duration(field func(*Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a valid duration", value)
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+func sizes(field func(*Config) *map[string]int64) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		items := map[string]int64{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			contentType, size, ok := strings.Cut(item, "=")
+			n, err := strconv.ParseInt(strings.TrimSpace(size), 10, 64)
+			if !ok || err != nil {
+				return fmt.Errorf("%q is not a valid size limit, expected content/type=bytes", item)
+			}
+			items[strings.TrimSpace(contentType)] = n
+		}
+		*field(c) = items
+		return nil
+	}
+}
+
+func variants(field func(*Config) *[]ImageVariantConfig) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		items := []ImageVariantConfig{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			name, size, ok := strings.Cut(item, "=")
+			width, height, ok2 := strings.Cut(size, "x")
+			w, err := strconv.Atoi(width)
+			h, err2 := strconv.Atoi(height)
+			if !ok || !ok2 || err != nil || err2 != nil {
+				return fmt.Errorf("%q is not a valid variant, expected name=WIDTHxHEIGHT", item)
+			}
+			items = append(items, ImageVariantConfig{Name: strings.TrimSpace(name), Width: w, Height: h})
+		}
+		*field(c) = items
+		return nil
+	}
+}
+
+func list(field func(*Config) *[]string) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		items := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*field(c) = items
+		return nil
+	}
+}
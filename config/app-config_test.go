@@ -0,0 +1,153 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// validConfig returns the defaults with the settings that have none.
+func validConfig() *Config {
+	c := Default()
+	c.Database.User = "todo"
+	c.Database.Name = "todo"
+	c.Auth.JWTSecret = "jwt-secret"
+	c.Auth.TokenSecret = "token-secret"
+	return c
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(`
+server:
+  port: 9001
+database:
+  host: file-host
+  user: file-user
+  name: file-name
+auth:
+  jwt_secret: file-secret
+log:
+  level: warn
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("SERVER_PORT", "9002")
+	t.Setenv("DB_HOST", "env-host")
+	// Unset, and restored after the test, so that the file values apply.
+	for _, name := range []string{"DB_USER", "DB_NAME", "JWT_SECRET", "TOKEN_SECRET", "LOG_LEVEL", "MAIL_TIMEOUT"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+
+	cfg, err := Load([]string{"-config", path, "-port", "9003"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		got, want any
+	}{
+		{"flag over env and file", cfg.Server.Port, 9003},
+		{"env over file", cfg.Database.Host, "env-host"},
+		{"file over default", cfg.Log.Level, "warn"},
+		{"default", cfg.Mail.Timeout, 30 * time.Second},
+		{"token secret from the JWT secret", cfg.Auth.TokenSecret, "file-secret"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadRejectsInvalidValues(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("SERVER_PORT", "eighty")
+
+	_, err := Load(nil)
+	if err == nil || !strings.Contains(err.Error(), "env SERVER_PORT") {
+		t.Errorf("error = %v, want one naming SERVER_PORT", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := validConfig().Validate(); err != nil {
+		t.Fatalf("valid config: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   string
+	}{
+		{"port out of range", func(c *Config) { c.Server.Port = 70000 }, "server.port"},
+		{"bad proxy range", func(c *Config) { c.Server.TrustedProxies = []string{"10.0.0.1"} }, "server.trusted_proxies"},
+		{"missing database user", func(c *Config) { c.Database.User = "" }, "database.user"},
+		{"more idle than open connections", func(c *Config) { c.Database.MaxIdleConns = 50 }, "database.max_idle_conns"},
+		{"missing JWT secret", func(c *Config) { c.Auth.JWTSecret = "" }, "auth.jwt_secret"},
+		{"unknown algorithm", func(c *Config) { c.Auth.JWTAlgorithm = "none" }, "auth.jwt_algorithm"},
+		{"unknown mail driver", func(c *Config) { c.Mail.Driver = "pigeon" }, "mail.driver"},
+		{"smtp without host", func(c *Config) { c.Mail.Driver = "smtp" }, "mail.smtp_host"},
+		{"zero mail timeout", func(c *Config) { c.Mail.Timeout = 0 }, "mail.timeout"},
+		{"duplicate variant", func(c *Config) {
+			c.Upload.Variants = []ImageVariantConfig{{"thumb", 1, 1}, {"thumb", 2, 2}}
+		}, "is used twice"},
+		{"credentials with any origin", func(c *Config) {
+			c.CORS.Enabled, c.CORS.AllowOrigins, c.CORS.AllowCredentials = true, []string{"*"}, true
+		}, "cors.allow_credentials"},
+		{"unknown log level", func(c *Config) { c.Log.Level = "loud" }, "log.level"},
+		{"lockout over its maximum", func(c *Config) { c.RateLimit.Login.MaxLockout = time.Second }, "rate_limit.login.max_lockout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			tt.change(c)
+			err := c.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want one mentioning %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryError(t *testing.T) {
+	c := validConfig()
+	c.Server.Port = 0
+	c.Database.Name = ""
+	c.Log.Format = "xml"
+
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("no error")
+	}
+	for _, want := range []string{"server.port", "database.name", "log.format"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error lacks %s: %v", want, err)
+		}
+	}
+}
+
+func TestDSN(t *testing.T) {
+	for _, password := range []string{"plain", "with space", "it's", `back\slash`, `' host=evil`, ""} {
+		d := DatabaseConfig{Host: "db.local", Port: 5433, User: "todo user", Password: password, Name: "todo", SSLMode: "disable"}
+
+		parsed, err := pgconn.ParseConfig(d.DSN())
+		if err != nil {
+			t.Errorf("password %q: %v", password, err)
+			continue
+		}
+		if parsed.Password != password || parsed.Host != d.Host || parsed.User != d.User || parsed.Database != d.Name || parsed.Port != 5433 {
+			t.Errorf("password %q: parsed as host %q, port %d, user %q, password %q, database %q",
+				password, parsed.Host, parsed.Port, parsed.User, parsed.Password, parsed.Database)
+		}
+	}
+}
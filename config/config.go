@@ -1,12 +1,17 @@
 package config
 
 import (
+	"context"
 	"log"
-	"os"
+	"log/slog"
+	"todo-app/logging"
+	"todo-app/metrics"
 	"todo-app/models"
+	"todo-app/tracing"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	gormLogger "gorm.io/gorm/logger"
 )
 
 var DB *gorm.DB
@@ -14,13 +19,138 @@ var DB *gorm.DB
 func Connect() {
 	var err error
 
-	dsn := "host=" + os.Getenv("DB_HOST") + " user=" + os.Getenv("DB_USER") + " password=" + os.Getenv("DB_PASSWORD") + " dbname=" + os.Getenv("DB_NAME") + " port=" + os.Getenv("DB_PORT") + " sslmode=disable"
-	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	DB, err = gorm.Open(postgres.Open(Cfg.Database.DSN()), &gorm.Config{
+		Logger: logging.GormLogger{
+			Logger:        slog.Default(),
+			SlowThreshold: Cfg.Log.SlowQueryThreshold,
+			LogLevel:      gormLogger.Info,
+		},
+	})
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
+
+	sqlDB, err := DB.DB()
+	if err != nil {
+		log.Fatal("Failed to get database handle:", err)
+	}
+	sqlDB.SetMaxOpenConns(Cfg.Database.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(Cfg.Database.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(Cfg.Database.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(Cfg.Database.ConnMaxIdleTime)
+
+	if err := DB.Use(metrics.GormPlugin{DBName: Cfg.Database.Name}); err != nil {
+		log.Fatal("Failed to register database metrics:", err)
+	}
+	if err := DB.Use(tracing.GormPlugin{DBName: Cfg.Database.Name}); err != nil {
+		log.Fatal("Failed to register database tracing:", err)
+	}
+}
+
+// Ping checks that the database is reachable.
+func Ping(ctx context.Context) error {
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// Close closes the database connection pool.
+func Close() error {
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
 }
 
 func Migrate() {
-	DB.AutoMigrate(&models.Task{}, &models.User{}, &models.Image{})
+	if err := dropNonCascadingForeignKeys(); err != nil {
+		log.Fatal("Failed to migrate foreign keys:", err)
+	}
+	DB.AutoMigrate(migratedModels...)
+	if err := lowercaseEmails(); err != nil {
+		log.Fatal("Failed to migrate user emails, accounts whose emails differ only in case must be merged or renamed first:", err)
+	}
+}
+
+// migratedModels are the models whose tables AutoMigrate manages.
+var migratedModels = []interface{}{&models.Task{}, &models.User{}, &models.Image{}, &models.ImageBlob{}, &models.ImageVariant{}, &models.UserToken{}, &models.Session{}, &models.RecoveryCode{}, &models.UserIdentity{}, &models.PersonalAccessToken{}, &models.SigningKey{}, &models.AuditLog{}, &models.TaskShare{}, &models.TaskLink{}, &models.ResumableUpload{}, &models.ResumableUploadChunk{}}
+
+// PromoteAdmins gives the admin role to the users listed in
+// auth.admin_emails.
+func PromoteAdmins() error {
+	if len(Cfg.Auth.AdminEmails) == 0 {
+		return nil
+	}
+	emails := make([]string, len(Cfg.Auth.AdminEmails))
+	for i, email := range Cfg.Auth.AdminEmails {
+		emails[i] = models.NormalizeEmail(email)
+	}
+	return DB.Model(&models.User{}).
+		Where("LOWER(email) IN ?", emails).
+		Update("role", models.RoleAdmin).Error
+}
+
+// cascadingRelations are the relations whose rows are deleted along with the
+// row they refer to. Their ON DELETE CASCADE used to be declared on the
+// foreign key field, where GORM ignores it, so existing databases have the
+// constraints without it.
+var cascadingRelations = []struct {
+	model    interface{}
+	relation string
+}{
+	{&models.RecoveryCode{}, "User"},
+	{&models.ImageBlob{}, "User"},
+	{&models.PersonalAccessToken{}, "User"},
+	{&models.UserIdentity{}, "User"},
+	{&models.Session{}, "User"},
+	{&models.UserToken{}, "User"},
+	{&models.TaskShare{}, "Task"},
+	{&models.TaskShare{}, "User"},
+	{&models.TaskLink{}, "Task"},
+	{&models.ImageVariant{}, "Image"},
+}
+
+// lowercaseEmails stores the emails of existing users in the form of
+// models.NormalizeEmail, and makes emails unique regardless of case. Emails
+// that would then collide with another account are left as they are, and
+// the index is not created until they are resolved.
+func lowercaseEmails() error {
+	err := DB.Exec(`UPDATE users SET email = LOWER(TRIM(email))
+		WHERE email <> LOWER(TRIM(email))
+		AND NOT EXISTS (SELECT 1 FROM users other WHERE other.id <> users.id AND LOWER(TRIM(other.email)) = LOWER(TRIM(users.email)))`).Error
+	if err != nil {
+		return err
+	}
+	return DB.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_lower ON users (LOWER(email))").Error
+}
+
+// dropNonCascadingForeignKeys drops the constraints of cascadingRelations
+// that do not cascade yet, for AutoMigrate to create them again.
+func dropNonCascadingForeignKeys() error {
+	for _, fk := range cascadingRelations {
+		stmt := &gorm.Statement{DB: DB}
+		if err := stmt.Parse(fk.model); err != nil {
+			return err
+		}
+		constraint := stmt.Schema.Relationships.Relations[fk.relation].ParseConstraint()
+
+		var rule string
+		err := DB.Raw("SELECT delete_rule FROM information_schema.referential_constraints WHERE constraint_schema = CURRENT_SCHEMA() AND constraint_name = ?", constraint.Name).
+			Scan(&rule).Error
+		if err != nil {
+			return err
+		}
+		if rule == "" || rule == "CASCADE" {
+			continue
+		}
+
+		slog.Info("recreating foreign key with ON DELETE CASCADE", "constraint", constraint.Name)
+		if err := DB.Migrator().DropConstraint(fk.model, constraint.Name); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"sync"
+	"testing"
+
+	"gorm.io/gorm/schema"
+)
+
+// nonCascadingForeignKeys are the foreign keys whose rows are deleted
+// explicitly, or must outlive the row they refer to.
+var nonCascadingForeignKeys = map[string]string{
+	"fk_users_tasks":  "tasks are deleted with their attachments when accounts are purged",
+	"fk_tasks_images": "attachments are deleted with their task by deleteTask",
+	"fk_images_blob":  "contents are shared between attachments and deleted when unreferenced",
+}
+
+func TestCascadingRelationsCascade(t *testing.T) {
+	cache := &sync.Map{}
+	for _, fk := range cascadingRelations {
+		s, err := schema.Parse(fk.model, cache, schema.NamingStrategy{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		relation, ok := s.Relationships.Relations[fk.relation]
+		if !ok {
+			t.Errorf("%s has no relation %s", s.Name, fk.relation)
+			continue
+		}
+		constraint := relation.ParseConstraint()
+		if constraint == nil {
+			t.Errorf("%s.%s has no foreign key", s.Name, fk.relation)
+			continue
+		}
+		if constraint.OnDelete != "CASCADE" {
+			t.Errorf("%s does not cascade: ON DELETE %q", constraint.Name, constraint.OnDelete)
+		}
+	}
+}
+
+// TestForeignKeysCascade checks every foreign key of the migrated models, so
+// that deleting a user, task or attachment is not blocked by rows such as
+// shares, links or variants that still refer to it.
+func TestForeignKeysCascade(t *testing.T) {
+	cache := &sync.Map{}
+	seen := map[string]bool{}
+	for _, model := range migratedModels {
+		s, err := schema.Parse(model, cache, schema.NamingStrategy{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, relation := range s.Relationships.Relations {
+			constraint := relation.ParseConstraint()
+			if constraint == nil || seen[constraint.Name] {
+				continue
+			}
+			seen[constraint.Name] = true
+
+			if _, ok := nonCascadingForeignKeys[constraint.Name]; ok {
+				continue
+			}
+			if constraint.OnDelete != "CASCADE" {
+				t.Errorf("%s of %s.%s does not cascade: ON DELETE %q", constraint.Name, s.Name, name, constraint.OnDelete)
+			}
+		}
+	}
+
+	for name := range nonCascadingForeignKeys {
+		if !seen[name] {
+			t.Errorf("%s is not a foreign key of the migrated models", name)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package controllers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"todo-app/config"
+	"todo-app/models"
+	"todo-app/models/dto"
+	"todo-app/utils"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// UpdateMe godoc
+// @Summary Update current user
+// @Description Change the username and/or email of the authenticated user. A new email address has to be verified again.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param profile body dto.UpdateProfileRequest true "Profile"
+// @Success 200 {object} dto.Response
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/me [patch]
+func UpdateMe(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	userID := utils.GetUserID(c)
+	var body dto.UpdateProfileRequest
+	var user models.User
+
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "invalid input",
+		})
+	}
+
+	if err := db.First(&user, userID).Error; err != nil {
+		return utils.InternalServerError(c, "could not retrieve user", err)
+	}
+
+	updates := map[string]interface{}{}
+	if body.Username != nil {
+		username := strings.TrimSpace(*body.Username)
+		if username == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "username must not be empty",
+			})
+		}
+		updates["username"] = username
+	}
+
+	emailChanged := false
+	if body.Email != nil && models.NormalizeEmail(*body.Email) != user.Email {
+		email := models.NormalizeEmail(*body.Email)
+		if email == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "email must not be empty",
+			})
+		}
+
+		var count int64
+		if err := db.Model(&models.User{}).Where("LOWER(email) = ? AND id <> ?", email, userID).Count(&count).Error; err != nil {
+			return utils.InternalServerError(c, "could not update user", err)
+		}
+		if count > 0 {
+			return c.JSON(http.StatusConflict, map[string]string{
+				"message": "email is already in use",
+			})
+		}
+
+		updates["email"] = email
+		updates["email_verified_at"] = nil
+		emailChanged = true
+	}
+
+	if len(updates) > 0 {
+		if err := db.Model(&user).Updates(updates).Error; err != nil {
+			return utils.InternalServerError(c, "could not update user", err)
+		}
+	}
+
+	if emailChanged {
+		if err := sendVerificationEmail(c.Request().Context(), db, user); err != nil {
+			slog.ErrorContext(c.Request().Context(), "could not send verification email", "error", err)
+		}
+	}
+
+	return c.JSON(http.StatusOK, dto.Response{
+		Message: "user updated successfully",
+		Data:    userResponse(user),
+	})
+}
+
+// userResponse describes user to themselves. Admin responses embed it.
+func userResponse(user models.User) dto.UserResponse {
+	return dto.UserResponse{
+		ID:            user.ID,
+		Username:      user.Username,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerifiedAt != nil,
+		TwoFactor:     user.TOTPEnabledAt != nil,
+		Role:          user.Role,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+
+		DeletionScheduledAt: user.DeletionScheduledAt,
+	}
+}
+
+// ChangePassword godoc
+// @Summary Change password
+// @Description Change the password of the authenticated user. All other sessions are logged out.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param password body dto.ChangePasswordRequest true "Passwords"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/me/password [post]
+func ChangePassword(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	userID := utils.GetUserID(c)
+	var body dto.ChangePasswordRequest
+	var user models.User
+
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "invalid input",
+		})
+	}
+	if body.NewPassword == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "new password is required",
+		})
+	}
+
+	if err := db.First(&user, userID).Error; err != nil {
+		return utils.InternalServerError(c, "could not retrieve user", err)
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.CurrentPassword)); err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"message": "current password is incorrect",
+		})
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(body.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return utils.InternalServerError(c, "failed to hash password", err)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("password", string(hashedPassword)).Error; err != nil {
+			return err
+		}
+		return revokeSessions(tx, user.ID, utils.GetSessionID(c))
+	})
+	if err != nil {
+		return utils.InternalServerError(c, "could not change password", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "password changed successfully, other sessions have been logged out",
+	})
+}
+
+// DeleteMe godoc
+// @Summary Delete current user
+// @Description Schedule the authenticated account for deletion. All sessions are logged out, and logging in again during the grace period restores the account. Afterwards the account, its tasks and their images are deleted permanently.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param account body dto.DeleteAccountRequest true "Password confirmation"
+// @Success 200 {object} dto.Response
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/me [delete]
+func DeleteMe(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	userID := utils.GetUserID(c)
+	var body dto.DeleteAccountRequest
+	var user models.User
+
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "invalid input",
+		})
+	}
+
+	if err := db.First(&user, userID).Error; err != nil {
+		return utils.InternalServerError(c, "could not retrieve user", err)
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.Password)); err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"message": "password is incorrect",
+		})
+	}
+
+	deleteAt := time.Now().Add(config.Cfg.Auth.AccountDeletionGrace)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("deletion_scheduled_at", deleteAt).Error; err != nil {
+			return err
+		}
+		return revokeSessions(tx, user.ID, "")
+	})
+	if err != nil {
+		return utils.InternalServerError(c, "could not delete account", err)
+	}
+
+	return c.JSON(http.StatusOK, dto.Response{
+		Message: "account scheduled for deletion, log in again before the deletion date to restore it",
+		Data: map[string]time.Time{
+			"deletion_scheduled_at": deleteAt,
+		},
+	})
+}
+
+// PurgeScheduledAccounts permanently deletes the accounts whose grace period
+// has ended, together with their tasks, images, tokens and sessions. Accounts
+// that fail are logged and tried again on the next run.
+func PurgeScheduledAccounts(ctx context.Context) error {
+	db := config.DB.WithContext(ctx)
+	var users []models.User
+
+	if err := db.Where("deletion_scheduled_at <= ?", time.Now()).Find(&users).Error; err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			taskIDs := tx.Model(&models.Task{}).Select("id").Where("user_id = ?", user.ID)
+			if err := tx.Where("task_id IN (?) OR user_id = ?", taskIDs, user.ID).Delete(&models.TaskShare{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("task_id IN (?)", taskIDs).Delete(&models.TaskLink{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("task_id IN (?) OR user_id = ?", taskIDs, user.ID).Delete(&models.ResumableUpload{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("task_id IN (?)", taskIDs).Delete(&models.Image{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", user.ID).Delete(&models.Task{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", user.ID).Delete(&models.ImageBlob{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", user.ID).Delete(&models.UserToken{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", user.ID).Delete(&models.Session{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", user.ID).Delete(&models.UserIdentity{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", user.ID).Delete(&models.PersonalAccessToken{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&user).Error
+		})
+		if err != nil {
+			// One account that cannot be purged must not hold up the
+			// others.
+			slog.ErrorContext(ctx, "could not purge deleted account", "deleted_user_id", user.ID, "error", err)
+			continue
+		}
+
+		slog.InfoContext(ctx, "purged deleted account", "deleted_user_id", user.ID)
+	}
+
+	return nil
+}
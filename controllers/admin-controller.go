@@ -0,0 +1,538 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"todo-app/config"
+	"todo-app/models"
+	"todo-app/models/dto"
+	"todo-app/utils"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPerPage = 50
+	maxPerPage     = 100
+)
+
+// AdminGetUsers godoc
+// @Summary List users
+// @Description Search users by username or email, optionally filtered by role and status. Requires the admin role.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param q query string false "Part of the username or email"
+// @Param role query string false "Role"
+// @Param disabled query bool false "Only disabled or only enabled users"
+// @Param page query int false "Page, starting at 1"
+// @Param per_page query int false "Users per page, at most 100"
+// @Success 200 {object} dto.Response{data=dto.PageResponse{items=[]dto.AdminUserResponse}}
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users [get]
+func AdminGetUsers(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	var users []models.User
+
+	page, perPage, ok := pagination(c)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "page and per_page must be positive numbers",
+		})
+	}
+
+	query := db.Model(&models.User{})
+	if q := strings.TrimSpace(c.QueryParam("q")); q != "" {
+		pattern := "%" + strings.ToLower(q) + "%"
+		query = query.Where("LOWER(username) LIKE ? OR LOWER(email) LIKE ?", pattern, pattern)
+	}
+	if role := c.QueryParam("role"); role != "" {
+		query = query.Where("role = ?", role)
+	}
+	if disabledParam := c.QueryParam("disabled"); disabledParam != "" {
+		disabled, err := strconv.ParseBool(disabledParam)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Invalid value for 'disabled' parameter. Use true or false.",
+			})
+		}
+		if disabled {
+			query = query.Where("disabled_at IS NOT NULL")
+		} else {
+			query = query.Where("disabled_at IS NULL")
+		}
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return utils.InternalServerError(c, "could not retrieve users", err)
+	}
+	if err := query.Order("id").Limit(perPage).Offset((page - 1) * perPage).Find(&users).Error; err != nil {
+		return utils.InternalServerError(c, "could not retrieve users", err)
+	}
+
+	details := map[string]interface{}{"query": c.QueryParams()}
+	if err := recordAudit(db, c, "users.list", nil, details); err != nil {
+		return utils.InternalServerError(c, "could not record audit log", err)
+	}
+
+	items := make([]dto.AdminUserResponse, 0, len(users))
+	for _, user := range users {
+		items = append(items, adminUserResponse(user))
+	}
+
+	return c.JSON(http.StatusOK, dto.Response{
+		Message: "success",
+		Data:    dto.PageResponse{Items: items, Total: total, Page: page, PerPage: perPage},
+	})
+}
+
+// AdminGetUser godoc
+// @Summary Get a user
+// @Description Get any user by ID. Requires the admin role.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} dto.Response{data=dto.AdminUserResponse}
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id} [get]
+func AdminGetUser(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+
+	user, ok, err := adminTargetUser(c, db)
+	if !ok {
+		return err
+	}
+
+	if err := recordAudit(db, c, "user.view", &user.ID, nil); err != nil {
+		return utils.InternalServerError(c, "could not record audit log", err)
+	}
+
+	return c.JSON(http.StatusOK, dto.Response{
+		Message: "success",
+		Data:    adminUserResponse(user),
+	})
+}
+
+// AdminGetUserTasks godoc
+// @Summary Get the tasks of a user
+// @Description Get all tasks of any user for support. Requires the admin role.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} dto.Response{data=[]dto.TaskResponse}
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id}/tasks [get]
+func AdminGetUserTasks(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	var tasks []models.Task
+
+	user, ok, err := adminTargetUser(c, db)
+	if !ok {
+		return err
+	}
+
+	if err := db.Where("user_id = ?", user.ID).Order("id").Preload("Images", orderImages).Find(&tasks).Error; err != nil {
+		return utils.InternalServerError(c, "could not retrieve tasks", err)
+	}
+
+	if err := recordAudit(db, c, "user.tasks.view", &user.ID, nil); err != nil {
+		return utils.InternalServerError(c, "could not record audit log", err)
+	}
+
+	taskResponses := []dto.TaskResponse{}
+	for _, task := range tasks {
+		taskResponses = append(taskResponses, taskResponse(task))
+	}
+
+	return c.JSON(http.StatusOK, dto.Response{Message: "success", Data: taskResponses})
+}
+
+// AdminDisableUser godoc
+// @Summary Disable a user
+// @Description Block a user from logging in and log out all their sessions. Requires the admin role.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id}/disable [post]
+func AdminDisableUser(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+
+	user, ok, err := adminTargetUser(c, db)
+	if !ok {
+		return err
+	}
+	if user.ID == utils.GetUserID(c) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "you cannot disable your own account",
+		})
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if user.DisabledAt == nil {
+			if err := tx.Model(&user).Update("disabled_at", time.Now()).Error; err != nil {
+				return err
+			}
+		}
+		if err := revokeSessions(tx, user.ID, ""); err != nil {
+			return err
+		}
+		return recordAudit(tx, c, "user.disable", &user.ID, nil)
+	})
+	if err != nil {
+		return utils.InternalServerError(c, "could not disable user", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "user disabled",
+	})
+}
+
+// AdminEnableUser godoc
+// @Summary Enable a user
+// @Description Allow a disabled user to log in again. Requires the admin role.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id}/enable [post]
+func AdminEnableUser(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+
+	user, ok, err := adminTargetUser(c, db)
+	if !ok {
+		return err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("disabled_at", nil).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, "user.enable", &user.ID, nil)
+	})
+	if err != nil {
+		return utils.InternalServerError(c, "could not enable user", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "user enabled",
+	})
+}
+
+// AdminUpdateUserRole godoc
+// @Summary Change the role of a user
+// @Description Set the role of a user to user or admin. Requires the admin role.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Param role body dto.RoleRequest true "Role"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id}/role [put]
+func AdminUpdateUserRole(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	var body dto.RoleRequest
+
+	if err := c.Bind(&body); err != nil || !slices.Contains(models.Roles, body.Role) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "role must be one of " + strings.Join(models.Roles, ", "),
+		})
+	}
+
+	user, ok, err := adminTargetUser(c, db)
+	if !ok {
+		return err
+	}
+	if user.ID == utils.GetUserID(c) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "you cannot change your own role",
+		})
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("role", body.Role).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, "user.role.update", &user.ID, map[string]interface{}{
+			"from": user.Role,
+			"to":   body.Role,
+		})
+	})
+	if err != nil {
+		return utils.InternalServerError(c, "could not update role", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "role updated",
+	})
+}
+
+// AdminUpdateUserQuota godoc
+// @Summary Change the storage quota of a user
+// @Description Override the default attachment quota of a user. Null restores the default and zero means unlimited. Requires the admin role.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Param quota body dto.QuotaRequest true "Quota"
+// @Success 200 {object} dto.Response{data=dto.AdminUserResponse}
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id}/quota [put]
+func AdminUpdateUserQuota(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	var body dto.QuotaRequest
+
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "invalid input",
+		})
+	}
+	if (body.QuotaBytes != nil && *body.QuotaBytes < 0) || (body.QuotaFiles != nil && *body.QuotaFiles < 0) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "quotas must not be negative",
+		})
+	}
+
+	user, ok, err := adminTargetUser(c, db)
+	if !ok {
+		return err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&user).Select("quota_bytes", "quota_files").Updates(models.User{
+			QuotaBytes: body.QuotaBytes,
+			QuotaFiles: body.QuotaFiles,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return recordAudit(tx, c, "user.quota.update", &user.ID, map[string]interface{}{
+			"quota_bytes": body.QuotaBytes,
+			"quota_files": body.QuotaFiles,
+		})
+	})
+	if err != nil {
+		return utils.InternalServerError(c, "could not update quota", err)
+	}
+	user.QuotaBytes, user.QuotaFiles = body.QuotaBytes, body.QuotaFiles
+
+	return c.JSON(http.StatusOK, dto.Response{
+		Message: "quota updated",
+		Data:    adminUserResponse(user),
+	})
+}
+
+// AdminForcePasswordReset godoc
+// @Summary Force a password reset
+// @Description Replace the password of a user with a random one, log out all their sessions and email them a password reset link. Requires the admin role.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id}/password-reset [post]
+func AdminForcePasswordReset(c echo.Context) error {
+	ctx := c.Request().Context()
+	db := config.DB.WithContext(ctx)
+
+	user, ok, err := adminTargetUser(c, db)
+	if !ok {
+		return err
+	}
+
+	password, err := utils.NewToken()
+	if err != nil {
+		return utils.InternalServerError(c, "could not reset password", err)
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return utils.InternalServerError(c, "could not reset password", err)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("password", string(hashedPassword)).Error; err != nil {
+			return err
+		}
+		if err := revokeSessions(tx, user.ID, ""); err != nil {
+			return err
+		}
+		return recordAudit(tx, c, "user.password.force_reset", &user.ID, nil)
+	})
+	if err != nil {
+		return utils.InternalServerError(c, "could not reset password", err)
+	}
+
+	if err := sendPasswordResetEmail(ctx, db, user); err != nil {
+		slog.ErrorContext(ctx, "could not send password reset email", "error", err)
+		return c.JSON(http.StatusOK, map[string]string{
+			"message": "password reset forced, but the email could not be sent",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "password reset forced, the user has been emailed a reset link",
+	})
+}
+
+// AdminGetAuditLogs godoc
+// @Summary List audit logs
+// @Description List recorded admin actions, newest first. Requires the admin role.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param actor_id query int false "Admin who acted"
+// @Param target_user_id query int false "User acted upon"
+// @Param action query string false "Action"
+// @Param page query int false "Page, starting at 1"
+// @Param per_page query int false "Entries per page, at most 100"
+// @Success 200 {object} dto.Response{data=dto.PageResponse{items=[]dto.AuditLogResponse}}
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/audit-logs [get]
+func AdminGetAuditLogs(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	var logs []models.AuditLog
+
+	page, perPage, ok := pagination(c)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "page and per_page must be positive numbers",
+		})
+	}
+
+	query := db.Model(&models.AuditLog{})
+	for _, column := range []string{"actor_id", "target_user_id"} {
+		if param := c.QueryParam(column); param != "" {
+			id, err := strconv.ParseUint(param, 10, 64)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"message": column + " must be a number",
+				})
+			}
+			query = query.Where(column+" = ?", id)
+		}
+	}
+	if action := c.QueryParam("action"); action != "" {
+		query = query.Where("action = ?", action)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return utils.InternalServerError(c, "could not retrieve audit logs", err)
+	}
+	if err := query.Order("id DESC").Limit(perPage).Offset((page - 1) * perPage).Find(&logs).Error; err != nil {
+		return utils.InternalServerError(c, "could not retrieve audit logs", err)
+	}
+
+	items := make([]dto.AuditLogResponse, 0, len(logs))
+	for _, log := range logs {
+		items = append(items, dto.AuditLogResponse{
+			ID:           log.ID,
+			ActorID:      log.ActorID,
+			Action:       log.Action,
+			TargetUserID: log.TargetUserID,
+			Details:      json.RawMessage(log.Details),
+			IP:           log.IP,
+			RequestID:    log.RequestID,
+			CreatedAt:    log.CreatedAt,
+		})
+	}
+
+	return c.JSON(http.StatusOK, dto.Response{
+		Message: "success",
+		Data:    dto.PageResponse{Items: items, Total: total, Page: page, PerPage: perPage},
+	})
+}
+
+// adminTargetUser loads the user named by the id path parameter. When ok is
+// false the error response has already been written and err is the result
+// of writing it.
+func adminTargetUser(c echo.Context, db *gorm.DB) (user models.User, ok bool, err error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return user, false, c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "invalid user ID",
+		})
+	}
+
+	err = db.First(&user, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, false, c.JSON(http.StatusNotFound, map[string]string{
+			"message": "user not found",
+		})
+	}
+	if err != nil {
+		return user, false, utils.InternalServerError(c, "could not retrieve user", err)
+	}
+
+	return user, true, nil
+}
+
+func pagination(c echo.Context) (page int, perPage int, ok bool) {
+	page, perPage = 1, defaultPerPage
+
+	if param := c.QueryParam("page"); param != "" {
+		value, err := strconv.Atoi(param)
+		if err != nil || value < 1 {
+			return 0, 0, false
+		}
+		page = value
+	}
+	if param := c.QueryParam("per_page"); param != "" {
+		value, err := strconv.Atoi(param)
+		if err != nil || value < 1 {
+			return 0, 0, false
+		}
+		perPage = min(value, maxPerPage)
+	}
+
+	return page, perPage, true
+}
+
+func adminUserResponse(user models.User) dto.AdminUserResponse {
+	return dto.AdminUserResponse{
+		UserResponse: userResponse(user),
+		DisabledAt:   user.DisabledAt,
+		QuotaBytes:   user.QuotaBytes,
+		QuotaFiles:   user.QuotaFiles,
+	}
+}
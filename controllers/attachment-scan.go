@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+	"todo-app/config"
+	"todo-app/metrics"
+	"todo-app/models"
+	"todo-app/scanner"
+
+	"gorm.io/gorm"
+)
+
+// errInfected is returned for uploads the malware scanner flags.
+var errInfected = errors.New("file was rejected by the malware scanner")
+
+// scanBatchSize bounds how many pending attachments ScanPendingImages checks
+// per run.
+const scanBatchSize = 100
+
+// scanImage checks the content of image with the malware scanner, while it
+// is quarantined as pending. Clean attachments are released. Infected ones
+// are deleted and reported in the audit log as entry, on behalf of the task
+// owner, and errInfected is returned. When the scanner fails the attachment
+// stays pending for ScanPendingImages to retry, and the error is returned.
+func scanImage(ctx context.Context, db *gorm.DB, image *models.Image, ownerID uint, data []byte, entry models.AuditLog) error {
+	result, err := scanner.Default.Scan(ctx, bytes.NewReader(data))
+	if err != nil {
+		metrics.AttachmentScansTotal.WithLabelValues("error").Inc()
+		return err
+	}
+
+	if !result.Infected {
+		metrics.AttachmentScansTotal.WithLabelValues("clean").Inc()
+		now := time.Now()
+		err := db.Model(image).Updates(map[string]interface{}{
+			"scan_status": models.ScanStatusClean,
+			"scanned_at":  now,
+		}).Error
+		if err == nil {
+			image.ScanStatus, image.ScannedAt = models.ScanStatusClean, &now
+		}
+		return err
+	}
+
+	metrics.AttachmentScansTotal.WithLabelValues("infected").Inc()
+	slog.WarnContext(ctx, "infected attachment rejected", "image_id", image.ID, "task_id", image.TaskID, "signature", result.Signature)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(image).Error; err != nil {
+			return err
+		}
+		// Content shared with another attachment is left to the scan of
+		// that one.
+		if image.BlobID != nil {
+			err := tx.Where("id = ? AND NOT EXISTS (SELECT 1 FROM images WHERE images.blob_id = image_blobs.id)", *image.BlobID).
+				Delete(&models.ImageBlob{}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		entry.Action = "attachment.infected"
+		entry.TargetUserID = &ownerID
+		return writeAudit(tx, entry, map[string]interface{}{
+			"task_id":   image.TaskID,
+			"filename":  image.Filename,
+			"sha256":    image.SHA256,
+			"signature": result.Signature,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return errInfected
+}
+
+// ScanPendingImages retries the malware scan of attachments that are still
+// quarantined, e.g. because the scanner was unavailable when they were
+// uploaded. The least recently tried go first, so that attachments that
+// always fail, such as files the scanner refuses, do not hold up the others.
+func ScanPendingImages(ctx context.Context) error {
+	db := config.DB.WithContext(ctx)
+	var images []models.Image
+	err := db.Where("scan_status = ?", models.ScanStatusPending).
+		Order("scan_attempted_at NULLS FIRST, id").
+		Limit(scanBatchSize).
+		Find(&images).Error
+	if err != nil {
+		return err
+	}
+
+	for _, image := range images {
+		if err := retryScan(ctx, db, image); err != nil {
+			// The next run tries again, after the other attachments.
+			slog.ErrorContext(ctx, "could not scan attachment", "image_id", image.ID, "attempts", image.ScanAttempts+1, "error", err)
+		}
+	}
+	return nil
+}
+
+// retryScan records an attempt to scan a pending attachment and makes it.
+func retryScan(ctx context.Context, db *gorm.DB, image models.Image) error {
+	err := db.Model(&image).Updates(map[string]interface{}{
+		"scan_attempts":     gorm.Expr("scan_attempts + 1"),
+		"scan_attempted_at": time.Now(),
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	var task models.Task
+	if err := db.Select("user_id").First(&task, image.TaskID).Error; err != nil {
+		return err
+	}
+	data, err := imageData(db, image)
+	if err != nil {
+		return err
+	}
+	entry := models.AuditLog{}
+	if image.UploaderID != nil {
+		entry.ActorID = *image.UploaderID
+	}
+	err = scanImage(ctx, db, &image, task.UserID, data, entry)
+	if errors.Is(err, errInfected) {
+		return nil
+	}
+	return err
+}
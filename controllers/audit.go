@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"encoding/json"
+	"todo-app/logging"
+	"todo-app/models"
+	"todo-app/utils"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// recordAudit stores an administrator action. Mutations call it inside their
+// transaction so that no change goes unrecorded.
+func recordAudit(tx *gorm.DB, c echo.Context, action string, targetUserID *uint, details map[string]interface{}) error {
+	return writeAudit(tx, models.AuditLog{
+		ActorID:      utils.GetUserID(c),
+		Action:       action,
+		TargetUserID: targetUserID,
+		IP:           c.RealIP(),
+		RequestID:    logging.RequestID(c.Request().Context()),
+	}, details)
+}
+
+// maxAuditField is the size of the ip and request_id columns of the audit
+// log.
+const maxAuditField = 64
+
+// writeAudit stores entry with details encoded, for events recorded outside
+// of a request.
+func writeAudit(tx *gorm.DB, entry models.AuditLog, details map[string]interface{}) error {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	encoded, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+
+	entry.Details = string(encoded)
+	// An oversized value must not make the audited change fail.
+	if len(entry.IP) > maxAuditField {
+		entry.IP = entry.IP[:maxAuditField]
+	}
+	if len(entry.RequestID) > maxAuditField {
+		entry.RequestID = entry.RequestID[:maxAuditField]
+	}
+	return tx.Create(&entry).Error
+}
@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"todo-app/config"
+	"todo-app/models"
+	"todo-app/utils"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// ExportTask godoc
+// @Summary Export a task as a ZIP archive
+// @Description Download a task the authenticated user owns or that has been shared with them as a ZIP archive, holding task.json with the task as returned by the API and every attachment under its original filename. Attachments still awaiting their malware scan are left out. The archive is streamed as it is built.
+// @Tags tasks
+// @Produce application/zip
+// @Security BearerAuth
+// @Param id path string true "Task ID"
+// @Success 200 {file} file
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /tasks/{id}/export.zip [get]
+func ExportTask(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+
+	task, _, err := findTask(db, c.Param("id"), utils.GetUserID(c), accessView)
+	if err != nil {
+		return taskAccessError(c, err)
+	}
+	if err := db.Scopes(orderImages).Where("task_id = ?", task.ID).Find(&task.Images).Error; err != nil {
+		return utils.InternalServerError(c, "could not retrieve task", err)
+	}
+
+	archive := startZip(c, fmt.Sprintf("task-%d.zip", task.ID))
+	if err := writeTaskExport(db, archive, "", task); err != nil {
+		return abortZip(c, err)
+	}
+	return finishZip(c, archive)
+}
+
+// ExportTasks godoc
+// @Summary Export all tasks as a ZIP archive
+// @Description Download every task the authenticated user owns as a ZIP archive, with a folder per task laid out as in the export of a single task. Tasks shared with the user are left out. The archive is streamed as it is built, one task at a time.
+// @Tags tasks
+// @Produce application/zip
+// @Security BearerAuth
+// @Success 200 {file} file
+// @Failure 500 {object} map[string]string
+// @Router /tasks/export.zip [get]
+func ExportTasks(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	var tasks []models.Task
+
+	// Attachments are loaded per task below, so that only those of one task
+	// are held at a time.
+	if err := db.Where("user_id = ?", utils.GetUserID(c)).Order("id").Find(&tasks).Error; err != nil {
+		return utils.InternalServerError(c, "could not retrieve tasks", err)
+	}
+
+	archive := startZip(c, "tasks.zip")
+	for _, task := range tasks {
+		if err := db.Scopes(orderImages).Where("task_id = ?", task.ID).Find(&task.Images).Error; err != nil {
+			return abortZip(c, err)
+		}
+		dir := fmt.Sprintf("%d-%s/", task.ID, exportName(task.Title, "task"))
+		if err := writeTaskExport(db, archive, dir, task); err != nil {
+			return abortZip(c, err)
+		}
+	}
+	return finishZip(c, archive)
+}
+
+// startZip sends the headers of a ZIP download called filename and returns a
+// writer streaming the archive into the response.
+func startZip(c echo.Context, filename string) *zip.Writer {
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, "application/zip")
+	header.Set("Content-Disposition", contentDisposition("attachment", filename))
+	header.Set("X-Content-Type-Options", "nosniff")
+	c.Response().WriteHeader(http.StatusOK)
+
+	return zip.NewWriter(c.Response())
+}
+
+func finishZip(c echo.Context, archive *zip.Writer) error {
+	if err := archive.Close(); err != nil {
+		return abortZip(c, err)
+	}
+	return nil
+}
+
+// abortZip logs a failure once the archive has started streaming. The status
+// has been sent already, but the archive is left without its central
+// directory, so clients see it as broken rather than incomplete.
+func abortZip(c echo.Context, err error) error {
+	slog.ErrorContext(c.Request().Context(), "could not export tasks", "error", err)
+	return nil
+}
+
+// writeTaskExport adds task.json and the scanned attachments of task, with
+// its images loaded, to archive under dir.
+func writeTaskExport(db *gorm.DB, archive *zip.Writer, dir string, task models.Task) error {
+	w, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     dir + "task.json",
+		Method:   zip.Deflate,
+		Modified: task.UpdatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(taskResponse(task)); err != nil {
+		return err
+	}
+
+	used := map[string]bool{"task.json": true}
+	for _, image := range task.Images {
+		if image.ScanStatus == models.ScanStatusPending {
+			continue
+		}
+		data, err := imageData(db, image)
+		if err != nil {
+			return err
+		}
+
+		// Images and archives are compressed already.
+		method := zip.Deflate
+		if image.IsImage() || image.ContentType == "application/zip" {
+			method = zip.Store
+		}
+		w, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     dir + uniqueName(used, exportName(image.Filename, fmt.Sprintf("attachment-%d", image.ID))),
+			Method:   method,
+			Modified: image.CreatedAt,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maxExportName bounds the length in characters of names in exports, below
+// the limit of common file systems.
+const maxExportName = 100
+
+// exportName turns a user supplied name into a single path element, so that
+// names like ../../etc/passwd cannot escape the archive when it is
+// extracted. Long names are shortened keeping their extension, and empty
+// results are replaced by fallback.
+func exportName(name, fallback string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(strings.TrimSpace(name), ".")
+	if runes := []rune(name); len(runes) > maxExportName {
+		ext := []rune(path.Ext(name))
+		if len(ext) > maxExportName/2 {
+			ext = nil
+		}
+		name = string(runes[:maxExportName-len(ext)]) + string(ext)
+	}
+	if name == "" {
+		return fallback
+	}
+	return name
+}
+
+// uniqueName returns name, numbered if it is in used already, and records it.
+func uniqueName(used map[string]bool, name string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	unique := name
+	for i := 2; used[strings.ToLower(unique)]; i++ {
+		unique = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	used[strings.ToLower(unique)] = true
+	return unique
+}
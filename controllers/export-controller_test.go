@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestExportName(t *testing.T) {
+	long := strings.Repeat("a", 150)
+	tests := []struct {
+		name, want string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"../../x", "_.._x"},
+		{"/etc/passwd", "_etc_passwd"},
+		{`..\..\boot.ini`, "_.._boot.ini"},
+		{"C:photo.jpg", "C_photo.jpg"},
+		{"a\x00b\nc\x7fd.txt", "a_b_c_d.txt"},
+		{`what?<is>"this"|*.txt`, "what__is__this___.txt"},
+		{".", "fallback"},
+		{"..", "fallback"},
+		{"....", "fallback"},
+		{" .. ", "fallback"},
+		{"", "fallback"},
+		{".hidden", "hidden"},
+		{long + ".jpeg", long[:95] + ".jpeg"},
+		{long + "." + strings.Repeat("b", 60), long[:100]},
+		{strings.Repeat("é", 150) + ".png", strings.Repeat("é", 96) + ".png"},
+	}
+	for _, tt := range tests {
+		got := exportName(tt.name, "fallback")
+		if got != tt.want {
+			t.Errorf("exportName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+		if n := utf8.RuneCountInString(got); n > maxExportName {
+			t.Errorf("exportName(%q) is %d characters long", tt.name, n)
+		}
+		if strings.ContainsAny(got, `/\`) {
+			t.Errorf("exportName(%q) = %q is not a single path element", tt.name, got)
+		}
+	}
+}
+
+func TestUniqueName(t *testing.T) {
+	used := map[string]bool{}
+	for _, tt := range []struct {
+		name, want string
+	}{
+		{"photo.jpg", "photo.jpg"},
+		{"Photo.JPG", "Photo (2).JPG"},
+		{"photo.jpg", "photo (3).jpg"},
+		{"notes", "notes"},
+		{"NOTES", "NOTES (2)"},
+		{"a (2).txt", "a (2).txt"},
+		{"a.txt", "a.txt"},
+		{"A.txt", "A (3).txt"},
+	} {
+		if got := uniqueName(used, tt.name); got != tt.want {
+			t.Errorf("uniqueName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
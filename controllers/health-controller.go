@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ReadinessCheck reports whether a dependency can serve requests.
+type ReadinessCheck func(ctx context.Context) error
+
+var (
+	shuttingDown    atomic.Bool
+	readinessMu     sync.RWMutex
+	readinessChecks = map[string]ReadinessCheck{}
+)
+
+// RegisterReadinessCheck adds a dependency that must be healthy for /readyz
+// to succeed, such as the database or a blob store.
+func RegisterReadinessCheck(name string, check ReadinessCheck) {
+	readinessMu.Lock()
+	defer readinessMu.Unlock()
+	readinessChecks[name] = check
+}
+
+// MarkShuttingDown makes /readyz fail so load balancers stop sending traffic.
+func MarkShuttingDown() {
+	shuttingDown.Store(true)
+}
+
+// Healthz reports that the process is alive.
+func Healthz(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{
+		"status": "ok",
+	})
+}
+
+// Readyz reports whether the instance is ready to receive traffic.
+func Readyz(c echo.Context) error {
+	if shuttingDown.Load() {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{
+			"status": "shutting down",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), 2*time.Second)
+	defer cancel()
+
+	readinessMu.RLock()
+	defer readinessMu.RUnlock()
+
+	status := http.StatusOK
+	checks := map[string]string{}
+	for name, check := range readinessChecks {
+		if err := check(ctx); err != nil {
+			status = http.StatusServiceUnavailable
+			// The probe is unauthenticated, so errors that may name hosts
+			// or credentials are only logged.
+			slog.ErrorContext(ctx, "readiness check failed", "check", name, "error", err)
+			checks[name] = "unavailable"
+			continue
+		}
+		checks[name] = "ok"
+	}
+
+	return c.JSON(status, checks)
+}
@@ -1,142 +1,786 @@
 package controllers
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 	"todo-app/config"
+	"todo-app/imaging"
+	"todo-app/logging"
+	"todo-app/metrics"
 	"todo-app/models"
 	"todo-app/models/dto"
 	dtoImage "todo-app/models/dto/dto-image"
+	"todo-app/upload"
 	"todo-app/utils"
 
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // UploadImage godoc
-// @Summary Upload an image
-// @Description Upload an image for a specific task. The image muse be a JPEG or PNG file and must not exceed 10 MB size.
+// @Summary Upload an attachment
+// @Description Upload a file for a specific task, in the image or file form field. The type is detected from the content and must be one of the allowed types (images, PDF, plain text and ZIP by default), and the file must not exceed the size limit of its type (10 MB by default). Metadata such as the location of photos is removed from images. Uploading content the task owner has already stored reuses it. Files are scanned for malware; infected files are rejected, and files the scanner could not check yet are stored as pending and cannot be downloaded until a later scan clears them.
 // @Tags images
 // @Accept multipart/form-data
 // @Produce json
 // @Security BearerAuth
 // @Param task_id path string true "Task ID"
-// @Param image formData file true "Image file"
+// @Param image formData file true "File"
 // @Success 200 {object} dto.Response
 // @Failure 400 {object} map[string]string
-// @Failure 502 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 413 {object} map[string]string "File, image or storage quota too large"
+// @Failure 415 {object} map[string]string
+// @Failure 422 {object} map[string]string "File is infected"
 // @Failure 500 {object} map[string]string
 // @Router /tasks/{task_id}/images [post]
 func UploadImage(c echo.Context) error {
-	taskID, err := utils.GetTaskID(c)
+	db := config.DB.WithContext(c.Request().Context())
+
+	task, _, err := findTask(db, c.Param("task_id"), utils.GetUserID(c), accessEdit)
 	if err != nil {
-		return c.JSON(http.StatusBadGateway, map[string]string{
-			"message": "invalid task id",
-		})
+		return taskAccessError(c, err)
 	}
 
-	file, err := c.FormFile("image")
+	file, err := upload.ReadPart(c.Request(), config.Cfg.Upload.AllowedTypes, config.Cfg.Upload.MaxSize, "image", "file")
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"message": "no file uploaded",
-		})
+		return uploadError(c, file, err)
 	}
-	src, err := file.Open()
+
+	image, err := storeUpload(c, db, task, file)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"message": "failed to open file",
-		})
+		return uploadError(c, file, err)
 	}
-	defer src.Close()
 
-	imgData := make([]byte, file.Size)
-	_, err = src.Read(imgData)
+	return c.JSON(http.StatusOK, dto.Response{
+		Message: "image upload successfully",
+		Data:    imageResponse(image, imageURL(image)),
+	})
+}
+
+// UploadImages godoc
+// @Summary Upload several attachments
+// @Description Upload several files for a specific task in one request, in image, images or file form fields. Every file is checked and stored as with a single upload, and the result of each is reported with the status it would have got on its own; a rejected file does not stop the others. At most 20 files and 100 MB are accepted per request by default.
+// @Tags images
+// @Accept multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param task_id path string true "Task ID"
+// @Param images formData file true "Files"
+// @Success 200 {object} dto.Response{data=[]dtoImage.UploadResult}
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 413 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /tasks/{task_id}/images/batch [post]
+func UploadImages(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	results := []dtoImage.UploadResult{}
+	uploaded := 0
+
+	task, _, err := findTask(db, c.Param("task_id"), utils.GetUserID(c), accessEdit)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"message": "failed to read file",
-		})
+		return taskAccessError(c, err)
 	}
 
-	image := models.Image{
-		TaskID:      uint(taskID),
-		Filename:    file.Filename,
-		Data:        imgData,
-		ContentType: file.Header.Get("Content-Type"),
-	}
+	err = upload.EachPart(c.Request(), config.Cfg.Upload.AllowedTypes, config.Cfg.Upload.MaxSize, func(file upload.File, err error) error {
+		result := dtoImage.UploadResult{Filename: file.Filename, Status: http.StatusOK}
+		if len(results) >= config.Cfg.Upload.MaxBatchFiles {
+			result.Status = http.StatusBadRequest
+			result.Message = fmt.Sprintf("at most %d files can be uploaded at once", config.Cfg.Upload.MaxBatchFiles)
+			results = append(results, result)
+			return nil
+		}
 
-	if err := config.DB.Create(&image).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"message": "failed to save image to database",
+		var image models.Image
+		if err == nil {
+			image, err = storeUpload(c, db, task, file)
+		}
+		if err != nil {
+			status, message, ok := uploadFailure(file, err)
+			if !ok {
+				return err
+			}
+			result.Status, result.Message = status, message
+		} else {
+			response := imageResponse(image, imageURL(image))
+			result.Image = &response
+			uploaded++
+		}
+
+		results = append(results, result)
+		return nil
+	}, "image", "images", "file")
+
+	// Files before a body that is cut off have been stored and are reported.
+	files := len(results)
+	if errors.Is(err, upload.ErrTooLarge) && files > 0 {
+		results = append(results, dtoImage.UploadResult{
+			Status:  http.StatusRequestEntityTooLarge,
+			Message: fmt.Sprintf("request exceeds %d bytes limit, the remaining files were not read", config.Cfg.Upload.MaxBatchSize),
 		})
+		err = nil
+	}
+	if err != nil {
+		return uploadError(c, upload.File{}, err)
 	}
 
 	return c.JSON(http.StatusOK, dto.Response{
-		Message: "image upload successfully",
-		Data: dtoImage.ImageResponse{
-			ID:          image.ID,
-			Filename:    image.Filename,
-			ContentType: image.ContentType,
-			CreatedAt:   image.CreatedAt,
-		},
+		Message: fmt.Sprintf("%d of %d files uploaded", uploaded, files),
+		Data:    results,
 	})
 }
 
 // GetImageByID godoc
-// @Summary Get an image by ID
-// @Description Retrieve an image by its ID
+// @Summary Get an attachment by ID
+// @Description Retrieve an attachment of a task that the authenticated user owns or that has been shared with them. Images are shown inline and other files downloaded. For images, pass variant to get one of the configured resized versions (thumb and medium by default), or w and h to fit the image within a custom size. Resized versions are generated on first request, and at most 10 custom sizes are kept per image; further sizes get 400. Responses carry an ETag and support conditional and range requests.
 // @Tags images
 // @Produce image/jpeg
 // @Produce image/png
+// @Produce application/octet-stream
+// @Security BearerAuth
 // @Param id path string true "Image ID"
+// @Param variant query string false "Variant name"
+// @Param w query int false "Maximum width in pixels"
+// @Param h query int false "Maximum height in pixels"
+// @Param Range header string false "Byte range, e.g. bytes=0-1023"
+// @Param If-None-Match header string false "ETag of a cached copy"
 // @Success 200 {file} file
+// @Success 206 {file} file
+// @Success 304 "Not modified"
+// @Failure 400 {object} map[string]string
 // @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string "Attachment is still being scanned"
+// @Failure 500 {object} map[string]string
 // @Router /images/{id} [get]
 func GetImageByID(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
 	var image models.Image
 	id := c.Param("id")
 
-	if err := config.DB.Where("id = ?", id).First(&image).Error; err != nil {
+	if err := db.Where("id = ?", id).First(&image).Error; err != nil {
 		return c.JSON(http.StatusNotFound, map[string]string{
 			"message": "image not found",
 		})
 	}
+	if _, _, err := findTask(db, image.TaskID, utils.GetUserID(c), accessView); err != nil {
+		if errors.Is(err, errTaskNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"message": "image not found",
+			})
+		}
+		return taskAccessError(c, err)
+	}
+
+	return serveImage(c, db, image, "private, max-age=300")
+}
+
+// GetTaskImages godoc
+// @Summary List the images of a task
+// @Description Get the images and other attachments of a task that the authenticated user owns or that has been shared with them, in their order
+// @Tags images
+// @Produce json
+// @Security BearerAuth
+// @Param task_id path string true "Task ID"
+// @Success 200 {object} dto.Response{data=[]dtoImage.ImageResponse}
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /tasks/{task_id}/images [get]
+func GetTaskImages(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	var images []models.Image
+
+	task, _, err := findTask(db, c.Param("task_id"), utils.GetUserID(c), accessView)
+	if err != nil {
+		return taskAccessError(c, err)
+	}
+
+	if err := db.Scopes(orderImages).Where("task_id = ?", task.ID).Find(&images).Error; err != nil {
+		return utils.InternalServerError(c, "could not retrieve images", err)
+	}
+
+	response := make([]dtoImage.ImageResponse, 0, len(images))
+	for _, image := range images {
+		response = append(response, imageResponse(image, imageURL(image)))
+	}
+
+	return c.JSON(http.StatusOK, dto.Response{Message: "success", Data: response})
+}
+
+// ReorderTaskImages godoc
+// @Summary Reorder the images of a task
+// @Description Set the order of the images of a task that the authenticated user owns or can edit through a share. Every image of the task must be listed exactly once.
+// @Tags images
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param task_id path string true "Task ID"
+// @Param order body dtoImage.ImageOrderRequest true "Image IDs in their new order"
+// @Success 200 {object} dto.Response{data=[]dtoImage.ImageResponse}
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /tasks/{task_id}/images/order [put]
+func ReorderTaskImages(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	var body dtoImage.ImageOrderRequest
+	var images []models.Image
+
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "invalid input",
+		})
+	}
+
+	task, _, err := findTask(db, c.Param("task_id"), utils.GetUserID(c), accessEdit)
+	if err != nil {
+		return taskAccessError(c, err)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("task_id = ?", task.ID).Find(&images).Error; err != nil {
+			return err
+		}
+
+		positions := make(map[uint]int, len(body.ImageIDs))
+		for i, id := range body.ImageIDs {
+			positions[id] = i
+		}
+		if len(positions) != len(body.ImageIDs) || len(positions) != len(images) {
+			return errInvalidOrder
+		}
+		for i := range images {
+			position, ok := positions[images[i].ID]
+			if !ok {
+				return errInvalidOrder
+			}
+			if err := tx.Model(&images[i]).Update("position", position).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errInvalidOrder) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
+		return utils.InternalServerError(c, "could not reorder images", err)
+	}
 
-	c.Response().Header().Set("Content-Type", image.ContentType)
-    c.Response().Header().Set("Content-Disposition", "inline; filename="+image.Filename)
+	slices.SortFunc(images, func(a, b models.Image) int { return a.Position - b.Position })
+	response := make([]dtoImage.ImageResponse, 0, len(images))
+	for _, image := range images {
+		response = append(response, imageResponse(image, imageURL(image)))
+	}
 
-	return c.Blob(http.StatusOK, image.ContentType, image.Data)
+	return c.JSON(http.StatusOK, dto.Response{Message: "images reordered", Data: response})
+}
+
+// UpdateImageByID godoc
+// @Summary Update an image by ID
+// @Description Change the caption or alt text of an attachment, or make an image the cover of its task, on a task that the authenticated user owns or can edit through a share
+// @Tags images
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Image ID"
+// @Param image body dtoImage.ImageUpdateRequest true "Fields to change"
+// @Success 200 {object} dto.Response{data=dtoImage.ImageResponse}
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /images/{id} [patch]
+func UpdateImageByID(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	var body dtoImage.ImageUpdateRequest
+	var image models.Image
+
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "invalid input",
+		})
+	}
+	if (body.Caption != nil && len(*body.Caption) > maxCaptionLength) || (body.AltText != nil && len(*body.AltText) > maxCaptionLength) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": fmt.Sprintf("caption and alt text must not exceed %d bytes", maxCaptionLength),
+		})
+	}
+
+	if err := db.Where("id = ?", c.Param("id")).First(&image).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": "image not found",
+		})
+	}
+	if _, _, err := findTask(db, image.TaskID, utils.GetUserID(c), accessEdit); err != nil {
+		if errors.Is(err, errTaskNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"message": "image not found",
+			})
+		}
+		return taskAccessError(c, err)
+	}
+	if body.Cover != nil && *body.Cover && !image.IsImage() {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "only images can be the cover of a task",
+		})
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if body.Caption != nil {
+			image.Caption = *body.Caption
+		}
+		if body.AltText != nil {
+			image.AltText = *body.AltText
+		}
+		if body.Cover != nil {
+			image.Cover = *body.Cover
+			if image.Cover {
+				err := tx.Model(&models.Image{}).Where("task_id = ? AND id <> ? AND cover", image.TaskID, image.ID).
+					Update("cover", false).Error
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Model(&image).Select("caption", "alt_text", "cover").Updates(&image).Error
+	})
+	if err != nil {
+		return utils.InternalServerError(c, "could not update image", err)
+	}
+
+	return c.JSON(http.StatusOK, dto.Response{
+		Message: "image updated",
+		Data:    imageResponse(image, imageURL(image)),
+	})
 }
 
 // DeleteImageByID godoc
-// @Summary Delete an image by ID
-// @Description Delete an image by its ID
+// @Summary Delete an attachment by ID
+// @Description Delete an attachment of a task that the authenticated user owns or can edit through a share
 // @Tags images
+// @Security BearerAuth
 // @Param id path string true "Image ID"
 // @Success 200 {object} dto.Response
+// @Failure 403 {object} map[string]string
 // @Failure 404 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /images/{id} [delete]
 func DeleteImageByID(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
 	var image models.Image
 	id := c.Param("id")
 
-	if err := config.DB.Where("id = ?", id).First(&image).Error; err != nil {
+	if err := db.Where("id = ?", id).First(&image).Error; err != nil {
 		return c.JSON(http.StatusNotFound, map[string]string{
 			"message": "image not found",
 		})
 	}
+	if _, _, err := findTask(db, image.TaskID, utils.GetUserID(c), accessEdit); err != nil {
+		if errors.Is(err, errTaskNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"message": "image not found",
+			})
+		}
+		return taskAccessError(c, err)
+	}
 
-	if err := config.DB.Delete(&image).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"message": "Could not delete image",
-		})
+	if err := db.Delete(&image).Error; err != nil {
+		return utils.InternalServerError(c, "Could not delete image", err)
 	}
 
 	return c.JSON(http.StatusOK, dto.Response{
 		Message: "image deleted successfully",
-		Data: dtoImage.ImageResponse{
-			ID:          image.ID,
-			Filename:    image.Filename,
-			ContentType: image.ContentType,
-			CreatedAt:   image.CreatedAt,
-		},
+		Data:    imageResponse(image, imageURL(image)),
+	})
+}
+
+// sanitizeImage removes the metadata from an uploaded image, returning it as
+// JSON when upload.keep_metadata is set. Other attachments, and images of
+// types the decoders do not understand, are returned as uploaded.
+func sanitizeImage(file upload.File) (upload.File, string, error) {
+	if !strings.HasPrefix(file.ContentType, "image/") {
+		return file, "", nil
+	}
+
+	sanitized, err := imaging.Sanitize(file.Data, config.Cfg.Upload.MaxImagePixels)
+	if errors.Is(err, imaging.ErrUnsupported) {
+		return file, "", nil
+	}
+	if err != nil {
+		return file, "", err
+	}
+	file = file.WithData(sanitized.Data)
+
+	if !config.Cfg.Upload.KeepMetadata || sanitized.Metadata == nil {
+		return file, "", nil
+	}
+	encoded, err := json.Marshal(sanitized.Metadata)
+	return file, string(encoded), err
+}
+
+// saveImage stores file as an image of task, quarantined until it has been
+// scanned, within the quota of the task owner. Content the task owner has
+// already stored is reused rather than stored again.
+func saveImage(db *gorm.DB, task models.Task, uploaderID uint, file upload.File, metadata string) (image models.Image, deduplicated bool, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := reserveStorage(tx, task.UserID, file.Size()); err != nil {
+			return err
+		}
+
+		blob := models.ImageBlob{
+			UserID:      task.UserID,
+			SHA256:      file.SHA256,
+			Size:        file.Size(),
+			ContentType: file.ContentType,
+			Data:        file.Data,
+		}
+		result := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "sha256"}},
+			DoNothing: true,
+		}).Create(&blob)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			deduplicated = true
+			if err := tx.Select("id").Where("user_id = ? AND sha256 = ?", task.UserID, file.SHA256).First(&blob).Error; err != nil {
+				return err
+			}
+		}
+
+		// New images go last.
+		var position int
+		err := tx.Model(&models.Image{}).Where("task_id = ?", task.ID).
+			Select("COALESCE(MAX(position) + 1, 0)").Scan(&position).Error
+		if err != nil {
+			return err
+		}
+
+		image = models.Image{
+			TaskID:      task.ID,
+			Position:    position,
+			Filename:    file.Filename,
+			BlobID:      &blob.ID,
+			Size:        file.Size(),
+			SHA256:      file.SHA256,
+			ContentType: file.ContentType,
+			Metadata:    metadata,
+			UploaderID:  &uploaderID,
+			ScanStatus:  models.ScanStatusPending,
+		}
+		// Content the decoders do not understand is stored without
+		// dimensions.
+		if image.IsImage() {
+			image.Width, image.Height, _ = imaging.Dimensions(file.Data)
+		}
+		return tx.Create(&image).Error
 	})
+
+	return image, deduplicated, err
+}
+
+// imageData returns the content of image. Images uploaded before content
+// moved to ImageBlob still hold it themselves.
+func imageData(db *gorm.DB, image models.Image) ([]byte, error) {
+	if image.BlobID == nil {
+		return image.Data, nil
+	}
+
+	var blob models.ImageBlob
+	err := db.Select("data").First(&blob, *image.BlobID).Error
+	return blob.Data, err
+}
+
+// maxCaptionLength bounds captions and alt texts.
+const maxCaptionLength = 1000
+
+var errInvalidOrder = errors.New("image_ids must list every image of the task exactly once")
+
+// orderImages sorts images in the order set for their task.
+func orderImages(db *gorm.DB) *gorm.DB {
+	return db.Order("images.position, images.id")
+}
+
+// maxCustomVariants bounds how many sizes requested with ?w= and ?h= are
+// stored per image. Further sizes are refused rather than resized on every
+// request, which public links would let anyone do.
+const maxCustomVariants = 10
+
+var errTooManyVariants = fmt.Errorf("this image has %d custom sizes already, request one of those or a named variant", maxCustomVariants)
+
+// serveImage writes image, or the resized version selected with ?variant=
+// or ?w= and ?h=. Images that cannot be resized, e.g. because the decoders
+// do not understand them, are served as uploaded. Attachments other than
+// images are served for download. Conditional and range requests are
+// answered from the ETag, which is derived from the content hash. Attachments
+// still awaiting their malware scan are not served.
+func serveImage(c echo.Context, db *gorm.DB, image models.Image, cacheControl string) error {
+	if image.ScanStatus == models.ScanStatusPending {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"message": "attachment is still being scanned",
+		})
+	}
+
+	name, width, height, err := requestedVariant(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+
+	disposition := "inline"
+	if !image.IsImage() {
+		if name != "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "only images have variants",
+			})
+		}
+		disposition = "attachment"
+	}
+	header := c.Response().Header()
+	header.Set("Content-Disposition", contentDisposition(disposition, image.Filename))
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Cache-Control", cacheControl)
+
+	if name != "" {
+		variant, err := imageVariant(db, image, name, width, height)
+		if err == nil {
+			etag := imageETag(image, variant.Data) + "-" + variant.Name
+			return serveContent(c, variant.ContentType, etag, variant.CreatedAt, variant.Data)
+		}
+		if errors.Is(err, errTooManyVariants) {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+		if !errors.Is(err, imaging.ErrUnsupported) && !errors.Is(err, imaging.ErrTooManyPixels) && !errors.Is(err, imaging.ErrInvalid) {
+			return utils.InternalServerError(c, "could not resize image", err)
+		}
+	}
+
+	data, err := imageData(db, image)
+	if err != nil {
+		return utils.InternalServerError(c, "could not retrieve image", err)
+	}
+
+	return serveContent(c, image.ContentType, imageETag(image, data), image.CreatedAt, data)
+}
+
+// serveContent writes data, answering If-None-Match, If-Modified-Since and
+// Range requests.
+func serveContent(c echo.Context, contentType, etag string, modified time.Time, data []byte) error {
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, contentType)
+	header.Set("ETag", `"`+etag+`"`)
+
+	http.ServeContent(c.Response(), c.Request(), "", modified, bytes.NewReader(data))
+	return nil
+}
+
+// imageETag returns the content hash of image. Images uploaded before
+// hashes were stored are hashed on the fly.
+func imageETag(image models.Image, data []byte) string {
+	if image.SHA256 != "" {
+		return image.SHA256
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// contentDisposition formats a Content-Disposition header, quoting or
+// encoding the filename as needed.
+func contentDisposition(disposition, filename string) string {
+	if header := mime.FormatMediaType(disposition, map[string]string{"filename": filename}); header != "" {
+		return header
+	}
+	return disposition
+}
+
+// requestedVariant returns the name and bounding box of the variant the
+// request asks for, or an empty name for the original. A custom size is
+// named after its box, e.g. 300x0 for ?w=300.
+func requestedVariant(c echo.Context) (name string, width, height int, err error) {
+	w, h := c.QueryParam("w"), c.QueryParam("h")
+	if w == "" && h == "" {
+		name = c.QueryParam("variant")
+		if name == "" {
+			return "", 0, 0, nil
+		}
+		variant, ok := config.Cfg.Upload.Variant(name)
+		if !ok {
+			return "", 0, 0, fmt.Errorf("unknown image variant %q", name)
+		}
+		return variant.Name, variant.Width, variant.Height, nil
+	}
+
+	limit := config.Cfg.Upload.MaxVariantDimension
+	for _, side := range []struct {
+		value string
+		dest  *int
+	}{{w, &width}, {h, &height}} {
+		if side.value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(side.value)
+		if err != nil || n <= 0 || n > limit {
+			return "", 0, 0, fmt.Errorf("w and h must be between 1 and %d", limit)
+		}
+		*side.dest = n
+	}
+
+	return fmt.Sprintf("%dx%d", width, height), width, height, nil
+}
+
+// imageVariant returns the stored variant of image called name, resizing the
+// image to fit within width x height the first time it is requested. Custom
+// sizes past maxCustomVariants return errTooManyVariants.
+func imageVariant(db *gorm.DB, image models.Image, name string, width, height int) (models.ImageVariant, error) {
+	var variant models.ImageVariant
+	err := db.Where("image_id = ? AND name = ?", image.ID, name).First(&variant).Error
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return variant, err
+	}
+
+	if _, ok := config.Cfg.Upload.Variant(name); !ok {
+		named := make([]string, 0, len(config.Cfg.Upload.Variants))
+		for _, v := range config.Cfg.Upload.Variants {
+			named = append(named, v.Name)
+		}
+		query := db.Model(&models.ImageVariant{}).Where("image_id = ?", image.ID)
+		if len(named) > 0 {
+			query = query.Where("name NOT IN ?", named)
+		}
+		var stored int64
+		if err := query.Count(&stored).Error; err != nil {
+			return variant, err
+		}
+		if stored >= maxCustomVariants {
+			return variant, errTooManyVariants
+		}
+	}
+
+	data, err := imageData(db, image)
+	if err != nil {
+		return variant, err
+	}
+	resized, err := imaging.Resize(data, width, height, config.Cfg.Upload.MaxImagePixels)
+	if err != nil {
+		return variant, err
+	}
+	variant = models.ImageVariant{
+		ImageID:     image.ID,
+		Name:        name,
+		Width:       resized.Width,
+		Height:      resized.Height,
+		ContentType: resized.ContentType,
+		Data:        resized.Data,
+	}
+
+	// A concurrent request may have stored the same variant already.
+	err = db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "image_id"}, {Name: "name"}},
+		DoNothing: true,
+	}).Create(&variant).Error
+
+	return variant, err
+}
+
+// storeUpload removes the metadata from file, stores it as an image of task
+// uploaded by the authenticated user and scans it for malware. Attachments
+// the scanner could not check are returned still pending.
+func storeUpload(c echo.Context, db *gorm.DB, task models.Task, file upload.File) (models.Image, error) {
+	file, metadata, err := sanitizeImage(file)
+	if err != nil {
+		return models.Image{}, err
+	}
+
+	uploaderID := utils.GetUserID(c)
+	image, deduplicated, err := saveImage(db, task, uploaderID, file, metadata)
+	if err != nil {
+		return image, err
+	}
+
+	metrics.ObserveImageUpload(len(file.Data))
+	if deduplicated {
+		metrics.ImageUploadDeduplicatedTotal.Inc()
+	}
+
+	err = scanImage(c.Request().Context(), db, &image, task.UserID, file.Data, models.AuditLog{
+		ActorID:   uploaderID,
+		IP:        c.RealIP(),
+		RequestID: logging.RequestID(c.Request().Context()),
+	})
+	if err != nil && !errors.Is(err, errInfected) {
+		slog.ErrorContext(c.Request().Context(), "could not scan attachment", "image_id", image.ID, "error", err)
+		return image, nil
+	}
+	return image, err
+}
+
+// uploadError answers a failed upload.Read or storeUpload of file.
+func uploadError(c echo.Context, file upload.File, err error) error {
+	status, message, ok := uploadFailure(file, err)
+	if !ok {
+		return utils.InternalServerError(c, "failed to upload file", err)
+	}
+	return c.JSON(status, map[string]string{
+		"message": message,
+	})
+}
+
+// uploadFailure returns the status and message of a failed upload of file, or
+// false if the error is not the client's.
+func uploadFailure(file upload.File, err error) (status int, message string, ok bool) {
+	var quotaErr *quotaError
+	switch {
+	case errors.As(err, &quotaErr):
+		return http.StatusRequestEntityTooLarge, quotaErr.Error(), true
+	case errors.Is(err, errInfected):
+		return http.StatusUnprocessableEntity, errInfected.Error(), true
+	case errors.Is(err, upload.ErrNoFile), errors.Is(err, upload.ErrEmpty):
+		return http.StatusBadRequest, "no file uploaded", true
+	case errors.Is(err, upload.ErrTooLarge):
+		// The body limit can be hit before the type is known.
+		limit := config.Cfg.Upload.LargestSize()
+		if file.ContentType != "" {
+			limit = config.Cfg.Upload.MaxSize(file.ContentType)
+		}
+		return http.StatusRequestEntityTooLarge, fmt.Sprintf("file exceeds %d bytes limit", limit), true
+	case errors.Is(err, imaging.ErrTooManyPixels):
+		return http.StatusRequestEntityTooLarge, fmt.Sprintf("image exceeds %d pixels limit", config.Cfg.Upload.MaxImagePixels), true
+	case errors.Is(err, imaging.ErrInvalid):
+		return http.StatusBadRequest, "image could not be decoded", true
+	case errors.Is(err, upload.ErrUnsupportedType):
+		return http.StatusUnsupportedMediaType, "file type is not allowed, allowed types are " + strings.Join(config.Cfg.Upload.AllowedTypes, ", "), true
+	default:
+		return http.StatusInternalServerError, "", false
+	}
+}
+
+// PurgeOrphanedImageBlobs deletes stored content no image refers to any
+// more, e.g. after its task was deleted.
+func PurgeOrphanedImageBlobs(ctx context.Context) error {
+	return config.DB.WithContext(ctx).
+		Where("NOT EXISTS (SELECT 1 FROM images WHERE images.blob_id = image_blobs.id)").
+		Delete(&models.ImageBlob{}).Error
 }
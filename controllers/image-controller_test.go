@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestServeContent(t *testing.T) {
+	data := []byte("0123456789")
+	modified := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		header       map[string]string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{"plain", nil, http.StatusOK, "0123456789", ""},
+		{"matching ETag", map[string]string{"If-None-Match": `"abc"`}, http.StatusNotModified, "", ""},
+		{"one of several ETags", map[string]string{"If-None-Match": `"old", "abc"`}, http.StatusNotModified, "", ""},
+		{"weak ETag", map[string]string{"If-None-Match": `W/"abc"`}, http.StatusNotModified, "", ""},
+		{"other ETag", map[string]string{"If-None-Match": `"old"`}, http.StatusOK, "0123456789", ""},
+		{"not modified since", map[string]string{"If-Modified-Since": modified.Add(time.Hour).Format(http.TimeFormat)}, http.StatusNotModified, "", ""},
+		{"modified since", map[string]string{"If-Modified-Since": modified.Add(-time.Hour).Format(http.TimeFormat)}, http.StatusOK, "0123456789", ""},
+		{"range", map[string]string{"Range": "bytes=2-5"}, http.StatusPartialContent, "2345", "bytes 2-5/10"},
+		{"open range", map[string]string{"Range": "bytes=7-"}, http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{"suffix range", map[string]string{"Range": "bytes=-3"}, http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{"unsatisfiable range", map[string]string{"Range": "bytes=20-"}, http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+		{"range of the current version", map[string]string{"Range": "bytes=2-5", "If-Range": `"abc"`}, http.StatusPartialContent, "2345", "bytes 2-5/10"},
+		{"range of an old version", map[string]string{"Range": "bytes=2-5", "If-Range": `"old"`}, http.StatusOK, "0123456789", ""},
+	}
+
+	e := echo.New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/images/1", nil)
+			for key, value := range tt.header {
+				req.Header.Set(key, value)
+			}
+			rec := httptest.NewRecorder()
+
+			if err := serveContent(e.NewContext(req, rec), "image/png", "abc", modified, data); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.body != "" && rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body, tt.body)
+			}
+			if tt.status == http.StatusNotModified && rec.Body.Len() > 0 {
+				t.Errorf("body sent with %d", rec.Code)
+			}
+			if got := rec.Header().Get("Content-Range"); got != tt.contentRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.contentRange)
+			}
+			// Errors are sent without the validators of the content.
+			if got := rec.Header().Get("ETag"); got != `"abc"` && tt.status < http.StatusBadRequest {
+				t.Errorf("ETag = %s, want \"abc\"", got)
+			}
+			if tt.status == http.StatusOK {
+				if got := rec.Header().Get(echo.HeaderContentType); got != "image/png" {
+					t.Errorf("Content-Type = %s, want image/png", got)
+				}
+				if got := rec.Header().Get("Last-Modified"); got != modified.Format(http.TimeFormat) {
+					t.Errorf("Last-Modified = %s, want %s", got, modified.Format(http.TimeFormat))
+				}
+			}
+		})
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		filename, want string
+	}{
+		{"report.pdf", `attachment; filename=report.pdf`},
+		{"my report.pdf", `attachment; filename="my report.pdf"`},
+		{`say "hi".txt`, `attachment; filename="say \"hi\".txt"`},
+		{`back\slash.txt`, `attachment; filename="back\\slash.txt"`},
+		{"résumé.pdf", `attachment; filename*=utf-8''r%C3%A9sum%C3%A9.pdf`},
+		{"数据 2026.csv", `attachment; filename*=utf-8''%E6%95%B0%E6%8D%AE%202026.csv`},
+		{"line\r\nSet-Cookie: a=b", `attachment; filename*=utf-8''line%0D%0ASet-Cookie%3A%20a%3Db`},
+	}
+	for _, tt := range tests {
+		got := contentDisposition("attachment", tt.filename)
+		if got != tt.want {
+			t.Errorf("contentDisposition(%q) = %s, want %s", tt.filename, got, tt.want)
+		}
+		if strings.ContainsAny(got, "\r\n") {
+			t.Errorf("contentDisposition(%q) spans several lines", tt.filename)
+		}
+
+		// Clients must read back the name that was stored.
+		disposition, params, err := mime.ParseMediaType(got)
+		if err != nil {
+			t.Errorf("contentDisposition(%q) = %s: %v", tt.filename, got, err)
+			continue
+		}
+		if disposition != "attachment" || params["filename"] != tt.filename {
+			t.Errorf("contentDisposition(%q) is read as %s with filename %q", tt.filename, disposition, params["filename"])
+		}
+	}
+}
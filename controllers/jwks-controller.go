@@ -0,0 +1,22 @@
+package controllers
+
+import (
+	"net/http"
+	"todo-app/signing"
+	"todo-app/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+// JWKS serves the public keys that verify access tokens. Tokens name their
+// key in the kid header; the set is empty when tokens are signed with HS256.
+func JWKS(c echo.Context) error {
+	set, err := signing.PublicKeys(c.Request().Context())
+	if err != nil {
+		return utils.InternalServerError(c, "could not load signing keys", err)
+	}
+
+	// Verifiers refetch on unknown key IDs, so a short cache is enough.
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, set)
+}
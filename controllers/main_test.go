@@ -0,0 +1,14 @@
+package controllers
+
+import (
+	"os"
+	"testing"
+	"todo-app/config"
+)
+
+func TestMain(m *testing.M) {
+	config.Cfg = config.Default()
+	config.Cfg.Auth.JWTSecret = "test-jwt-secret"
+	config.Cfg.Auth.TokenSecret = "test-token-secret"
+	os.Exit(m.Run())
+}
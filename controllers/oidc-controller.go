@@ -0,0 +1,332 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"todo-app/config"
+	"todo-app/models"
+	"todo-app/sso"
+	"todo-app/utils"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcStateTTL    = 10 * time.Minute
+)
+
+var (
+	errEmailNotVerified = errors.New("identity provider did not return a verified email address")
+	errSignupDisabled   = errors.New("no account exists for this email address")
+)
+
+// oidcState is kept in an encrypted cookie between the redirect to the
+// identity provider and the callback.
+type oidcState struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// OIDCLogin godoc
+// @Summary Log in with an identity provider
+// @Description Redirect to the login page of the configured OpenID Connect provider, using the authorization code flow with PKCE.
+// @Tags auth
+// @Param provider path string true "Provider name"
+// @Success 302
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/oidc/{provider}/login [get]
+func OIDCLogin(c echo.Context) error {
+	provider, err := sso.Get(c.Request().Context(), c.Param("provider"))
+	if errors.Is(err, sso.ErrUnknownProvider) {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": "identity provider not found",
+		})
+	}
+	if err != nil {
+		return utils.InternalServerError(c, "identity provider is unavailable", err)
+	}
+
+	state := oidcState{Provider: provider.Config.Name, Verifier: oauth2.GenerateVerifier()}
+	if state.State, err = utils.NewToken(); err != nil {
+		return utils.InternalServerError(c, "could not start login", err)
+	}
+	if state.Nonce, err = utils.NewToken(); err != nil {
+		return utils.InternalServerError(c, "could not start login", err)
+	}
+
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return utils.InternalServerError(c, "could not start login", err)
+	}
+	sealed, err := utils.EncryptSecret(string(raw))
+	if err != nil {
+		return utils.InternalServerError(c, "could not start login", err)
+	}
+	c.SetCookie(oidcCookie(c, sealed, int(oidcStateTTL.Seconds())))
+
+	authURL := provider.OAuth2.AuthCodeURL(state.State,
+		oauth2.S256ChallengeOption(state.Verifier),
+		oauth2.SetAuthURLParam("nonce", state.Nonce),
+	)
+
+	return c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback godoc
+// @Summary Complete an identity provider login
+// @Description Exchange the authorization code returned by the identity provider. The account is found by the provider identity, then linked by verified email address, and created when the provider allows signup. The response is the same as /auth/login, or a redirect carrying it in the URL fragment when the provider has a frontend redirect URL.
+// @Tags auth
+// @Produce json
+// @Param provider path string true "Provider name"
+// @Param code query string true "Authorization code"
+// @Param state query string true "State"
+// @Success 200 {object} map[string]string
+// @Success 302
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/oidc/{provider}/callback [get]
+func OIDCCallback(c echo.Context) error {
+	ctx := c.Request().Context()
+	db := config.DB.WithContext(ctx)
+
+	provider, err := sso.Get(ctx, c.Param("provider"))
+	if errors.Is(err, sso.ErrUnknownProvider) {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": "identity provider not found",
+		})
+	}
+	if err != nil {
+		return utils.InternalServerError(c, "identity provider is unavailable", err)
+	}
+
+	// The state cookie is single use.
+	c.SetCookie(oidcCookie(c, "", -1))
+
+	if reason := c.QueryParam("error"); reason != "" {
+		slog.InfoContext(ctx, "identity provider login failed", "provider", provider.Config.Name, "error", reason)
+		return oidcFailure(c, provider, http.StatusUnauthorized, "login was cancelled or denied")
+	}
+
+	state, ok := readOIDCState(c)
+	if !ok || state.Provider != provider.Config.Name ||
+		subtle.ConstantTimeCompare([]byte(state.State), []byte(c.QueryParam("state"))) != 1 {
+		return oidcFailure(c, provider, http.StatusBadRequest, "invalid or expired login state, try again")
+	}
+
+	claims, err := provider.Exchange(ctx, c.QueryParam("code"), state.Verifier, state.Nonce)
+	if err != nil {
+		slog.WarnContext(ctx, "could not verify identity provider response", "provider", provider.Config.Name, "error", err)
+		return oidcFailure(c, provider, http.StatusUnauthorized, "could not verify identity provider response")
+	}
+
+	user, err := resolveOIDCUser(db, provider.Config, claims)
+	if errors.Is(err, errEmailNotVerified) || errors.Is(err, errSignupDisabled) {
+		return oidcFailure(c, provider, http.StatusForbidden, err.Error())
+	}
+	if err != nil {
+		return utils.InternalServerError(c, "could not log in", err)
+	}
+
+	response, err := loginResponse(db, user)
+	if errors.Is(err, errAccountDisabled) {
+		return oidcFailure(c, provider, http.StatusForbidden, err.Error())
+	}
+	if err != nil {
+		return utils.InternalServerError(c, "could not complete login", err)
+	}
+
+	if provider.Config.FrontendRedirectURL == "" {
+		return c.JSON(http.StatusOK, response)
+	}
+
+	fragment := url.Values{}
+	for key, value := range response {
+		fragment.Set(key, fmt.Sprint(value))
+	}
+	return c.Redirect(http.StatusFound, provider.Config.FrontendRedirectURL+"#"+fragment.Encode())
+}
+
+// resolveOIDCUser returns the account for the identity in claims, linking it
+// to an existing account with the same email address or creating one. The
+// provider has verified the address, so an existing account whose address
+// was never verified is claimed for the identity; see claimUnverifiedAccount.
+func resolveOIDCUser(db *gorm.DB, provider config.OIDCProviderConfig, claims sso.Claims) (models.User, error) {
+	var user models.User
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var identity models.UserIdentity
+		err := tx.Preload("User").
+			Where("provider = ? AND subject = ?", provider.Name, claims.Subject).
+			First(&identity).Error
+		if err == nil {
+			user = identity.User
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		// Linking by email is only safe when the provider vouches for it.
+		if claims.Email == "" || !claims.EmailVerified {
+			return errEmailNotVerified
+		}
+
+		now := time.Now()
+		err = tx.Where("LOWER(email) = ?", models.NormalizeEmail(claims.Email)).First(&user).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if !provider.AllowSignup {
+				return errSignupDisabled
+			}
+			if user, err = newOIDCUser(claims); err != nil {
+				return err
+			}
+			user.EmailVerifiedAt = &now
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case user.EmailVerifiedAt == nil:
+			if err := claimUnverifiedAccount(tx, &user, now); err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(&models.UserIdentity{
+			UserID:   user.ID,
+			Provider: provider.Name,
+			Subject:  claims.Subject,
+			Email:    claims.Email,
+		}).Error
+	})
+
+	return user, err
+}
+
+// claimUnverifiedAccount marks the address of user as verified for the owner
+// of the identity being linked. Anyone can register an account with an
+// address they do not own, so every credential set up before the address
+// was verified is revoked: the password is replaced, two-factor
+// authentication is turned off, and sessions, personal access tokens and
+// emailed links stop working.
+func claimUnverifiedAccount(tx *gorm.DB, user *models.User, now time.Time) error {
+	hashedPassword, err := randomPasswordHash()
+	if err != nil {
+		return err
+	}
+
+	user.Password, user.EmailVerifiedAt = hashedPassword, &now
+	user.TOTPSecret, user.TOTPEnabledAt, user.TOTPLastStep = "", nil, 0
+	err = tx.Model(user).Select("password", "email_verified_at", "totp_secret", "totp_enabled_at", "totp_last_step").Updates(user).Error
+	if err != nil {
+		return err
+	}
+	if err := revokeSessions(tx, user.ID, ""); err != nil {
+		return err
+	}
+	for _, model := range []interface{}{&models.PersonalAccessToken{}, &models.RecoveryCode{}, &models.UserToken{}, &models.UserIdentity{}} {
+		if err := tx.Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+
+	slog.WarnContext(tx.Statement.Context, "unverified account claimed through identity provider", "user_id", user.ID)
+	return nil
+}
+
+// newOIDCUser builds an account for a first login through an identity
+// provider. The random password can only be replaced through a password
+// reset.
+func newOIDCUser(claims sso.Claims) (models.User, error) {
+	hashedPassword, err := randomPasswordHash()
+	if err != nil {
+		return models.User{}, err
+	}
+
+	username := claims.PreferredUsername
+	if username == "" {
+		username = claims.Name
+	}
+	if username == "" {
+		username, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	return models.User{
+		Username: username,
+		Email:    models.NormalizeEmail(claims.Email),
+		Password: hashedPassword,
+	}, nil
+}
+
+// randomPasswordHash returns the hash of a random password nobody knows.
+func randomPasswordHash() (string, error) {
+	password, err := utils.NewToken()
+	if err != nil {
+		return "", err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hashedPassword), err
+}
+
+func readOIDCState(c echo.Context) (oidcState, bool) {
+	var state oidcState
+
+	cookie, err := c.Cookie(oidcStateCookie)
+	if err != nil || cookie.Value == "" {
+		return state, false
+	}
+	raw, err := utils.DecryptSecret(cookie.Value)
+	if err != nil {
+		return state, false
+	}
+	if err := json.Unmarshal([]byte(raw), &state); err != nil {
+		return state, false
+	}
+
+	return state, true
+}
+
+func oidcCookie(c echo.Context, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/api/auth/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		// Lax lets the cookie through on the top-level redirect back from
+		// the identity provider.
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// oidcFailure answers a failed login with JSON, or sends the browser back to
+// the frontend with the error in the URL fragment.
+func oidcFailure(c echo.Context, provider *sso.Provider, status int, message string) error {
+	if provider.Config.FrontendRedirectURL == "" {
+		return c.JSON(status, map[string]string{
+			"message": message,
+		})
+	}
+
+	fragment := url.Values{"error": {message}}
+	return c.Redirect(http.StatusFound, provider.Config.FrontendRedirectURL+"#"+fragment.Encode())
+}
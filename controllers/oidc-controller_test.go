@@ -0,0 +1,270 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"todo-app/config"
+	"todo-app/sso"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeResult is the answer to a query.
+type fakeResult struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+// fakeDB is a database/sql connector answering queries from canned results,
+// for code that runs transactions, which dry-run mode cannot. Queries are
+// answered by answer when it is set and returns a result, then by the result
+// whose key they start with, or with no rows.
+type fakeDB struct {
+	results map[string]fakeResult
+	answer  func(query string, args []driver.Value) *fakeResult
+
+	mu         sync.Mutex
+	statements []string
+	args       [][]driver.Value
+}
+
+// fakeGormDB opens a database answering with results.
+func fakeGormDB(t *testing.T, results map[string]fakeResult) (*gorm.DB, *fakeDB) {
+	t.Helper()
+	fake := &fakeDB{results: results}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(fake)}), &gorm.Config{
+		DisableAutomaticPing: true,
+		Logger:               logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, fake
+}
+
+// argsOf returns the arguments of the statements starting with prefix.
+func (f *fakeDB) argsOf(prefix string) [][]driver.Value {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var found [][]driver.Value
+	for i, statement := range f.statements {
+		if strings.HasPrefix(statement, prefix) {
+			found = append(found, f.args[i])
+		}
+	}
+	return found
+}
+
+// ran reports whether a statement starting with prefix was run.
+func (f *fakeDB) ran(prefix string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, statement := range f.statements {
+		if strings.HasPrefix(statement, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeDB) record(statement string, named []driver.NamedValue) []driver.Value {
+	args := make([]driver.Value, len(named))
+	for i, arg := range named {
+		args[i] = arg.Value
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.statements = append(f.statements, statement)
+	f.args = append(f.args, args)
+	return args
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return fakeDriver{f} }
+
+type fakeDriver struct{ db *fakeDB }
+
+func (d fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{d.db}, nil }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c fakeConn) Close() error                        { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)           { return fakeTx{c.db}, nil }
+
+func (c fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.record(query, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (c fakeConn) QueryContext(_ context.Context, query string, named []driver.NamedValue) (driver.Rows, error) {
+	args := c.db.record(query, named)
+	if c.db.answer != nil {
+		if result := c.db.answer(query, args); result != nil {
+			return &fakeRows{result: *result}, nil
+		}
+	}
+	for prefix, result := range c.db.results {
+		if strings.HasPrefix(query, prefix) {
+			return &fakeRows{result: result}, nil
+		}
+	}
+	return &fakeRows{}, nil
+}
+
+type fakeTx struct{ db *fakeDB }
+
+func (tx fakeTx) Commit() error   { tx.db.record("COMMIT", nil); return nil }
+func (tx fakeTx) Rollback() error { tx.db.record("ROLLBACK", nil); return nil }
+
+type fakeRows struct {
+	result fakeResult
+	next   int
+}
+
+func (r *fakeRows) Columns() []string { return r.result.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next == len(r.result.rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.rows[r.next])
+	r.next++
+	return nil
+}
+
+var userColumns = []string{"id", "username", "email", "password", "email_verified_at", "totp_secret", "totp_enabled_at", "totp_last_step"}
+
+func TestResolveOIDCUser(t *testing.T) {
+	provider := config.OIDCProviderConfig{Name: "corp", AllowSignup: true}
+	claims := sso.Claims{Subject: "subject-1", Email: "Ada@Example.com", EmailVerified: true}
+	verifiedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	t.Run("existing identity", func(t *testing.T) {
+		db, fake := fakeGormDB(t, map[string]fakeResult{
+			`SELECT * FROM "user_identities"`: {[]string{"id", "user_id", "provider", "subject"}, [][]driver.Value{{int64(1), int64(7), "corp", "subject-1"}}},
+			`SELECT * FROM "users"`:           {userColumns, [][]driver.Value{{int64(7), "ada", "ada@example.com", "hash", verifiedAt, "", nil, int64(0)}}},
+		})
+
+		user, err := resolveOIDCUser(db, provider, claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.ID != 7 {
+			t.Errorf("user = %d, want 7", user.ID)
+		}
+		for _, statement := range []string{"INSERT", "UPDATE", "DELETE"} {
+			if fake.ran(statement) {
+				t.Errorf("%s run for a known identity", statement)
+			}
+		}
+	})
+
+	t.Run("verified account", func(t *testing.T) {
+		db, fake := fakeGormDB(t, map[string]fakeResult{
+			`SELECT * FROM "users" WHERE LOWER(email) = $1`: {userColumns, [][]driver.Value{{int64(8), "ada", "ada@example.com", "hash", verifiedAt, "secret", verifiedAt, int64(3)}}},
+		})
+
+		user, err := resolveOIDCUser(db, provider, claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.ID != 8 || user.Password != "hash" || user.TOTPEnabledAt == nil {
+			t.Errorf("user = %d with password %q and TOTP enabled at %v, want 8 unchanged", user.ID, user.Password, user.TOTPEnabledAt)
+		}
+		if !fake.ran(`INSERT INTO "user_identities"`) {
+			t.Error("identity not linked")
+		}
+		if fake.ran("UPDATE") || fake.ran("DELETE") {
+			t.Errorf("credentials of a verified account changed: %q", fake.statements)
+		}
+	})
+
+	t.Run("unverified account", func(t *testing.T) {
+		db, fake := fakeGormDB(t, map[string]fakeResult{
+			`SELECT * FROM "users" WHERE LOWER(email) = $1`: {userColumns, [][]driver.Value{{int64(9), "ada", "ada@example.com", "hash", nil, "secret", verifiedAt, int64(3)}}},
+		})
+
+		user, err := resolveOIDCUser(db, provider, claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.ID != 9 || user.EmailVerifiedAt == nil {
+			t.Errorf("user = %d verified at %v, want 9 verified", user.ID, user.EmailVerifiedAt)
+		}
+		if user.Password == "hash" || user.TOTPSecret != "" || user.TOTPEnabledAt != nil {
+			t.Error("password or two-factor authentication kept")
+		}
+		// Whoever registered the address may not own it, so nothing they
+		// set up may keep working.
+		for _, statement := range []string{
+			`UPDATE "users" SET "password"=`,
+			`UPDATE "sessions" SET "revoked_at"=`,
+			`DELETE FROM "personal_access_tokens" WHERE user_id = $1`,
+			`DELETE FROM "recovery_codes" WHERE user_id = $1`,
+			`DELETE FROM "user_tokens" WHERE user_id = $1`,
+			`DELETE FROM "user_identities" WHERE user_id = $1`,
+			`INSERT INTO "user_identities"`,
+			"COMMIT",
+		} {
+			if !fake.ran(statement) {
+				t.Errorf("%s not run: %q", statement, fake.statements)
+			}
+		}
+	})
+
+	t.Run("new account", func(t *testing.T) {
+		db, fake := fakeGormDB(t, map[string]fakeResult{
+			`INSERT INTO "users"`: {[]string{"id"}, [][]driver.Value{{int64(10)}}},
+		})
+
+		user, err := resolveOIDCUser(db, provider, claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.ID != 10 || user.Email != "ada@example.com" || user.EmailVerifiedAt == nil {
+			t.Errorf("user = %d with email %q verified at %v, want 10 with ada@example.com verified", user.ID, user.Email, user.EmailVerifiedAt)
+		}
+		if !fake.ran(`INSERT INTO "user_identities"`) {
+			t.Error("identity not linked")
+		}
+	})
+
+	t.Run("signup disabled", func(t *testing.T) {
+		db, fake := fakeGormDB(t, nil)
+
+		closed := config.OIDCProviderConfig{Name: "corp"}
+		if _, err := resolveOIDCUser(db, closed, claims); !errors.Is(err, errSignupDisabled) {
+			t.Errorf("error = %v, want %v", err, errSignupDisabled)
+		}
+		if fake.ran("INSERT") {
+			t.Error("account created")
+		}
+	})
+
+	t.Run("unverified email", func(t *testing.T) {
+		db, fake := fakeGormDB(t, map[string]fakeResult{
+			`SELECT * FROM "users"`: {userColumns, [][]driver.Value{{int64(8), "ada", "ada@example.com", "hash", verifiedAt, "", nil, int64(0)}}},
+		})
+
+		unverified := claims
+		unverified.EmailVerified = false
+		if _, err := resolveOIDCUser(db, provider, unverified); !errors.Is(err, errEmailNotVerified) {
+			t.Errorf("error = %v, want %v", err, errEmailNotVerified)
+		}
+		if fake.ran(`SELECT * FROM "users"`) || fake.ran("INSERT") || !fake.ran("ROLLBACK") {
+			t.Errorf("account looked up or linked: %q", fake.statements)
+		}
+	})
+}
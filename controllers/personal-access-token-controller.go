@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"todo-app/config"
+	"todo-app/models"
+	"todo-app/models/dto"
+	"todo-app/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+// CreatePersonalAccessToken godoc
+// @Summary Create a personal access token
+// @Description Create a named token for scripts, limited to the given scopes (tasks:read, tasks:write, images:write). The token is only returned once.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param token body dto.PersonalAccessTokenRequest true "Token"
+// @Success 201 {object} dto.Response{data=dto.PersonalAccessTokenResponse}
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/tokens [post]
+func CreatePersonalAccessToken(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	userID := utils.GetUserID(c)
+	var body dto.PersonalAccessTokenRequest
+
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "invalid input",
+		})
+	}
+
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" || len(body.Name) > 100 {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "name is required and must be at most 100 characters",
+		})
+	}
+	if len(body.Scopes) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "at least one scope is required",
+		})
+	}
+	for _, scope := range body.Scopes {
+		if !slices.Contains(models.Scopes, scope) {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "unknown scope " + strconv.Quote(scope) + ", expected one of " + strings.Join(models.Scopes, ", "),
+			})
+		}
+	}
+	if body.ExpiresAt != nil && !body.ExpiresAt.After(time.Now()) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "expires_at must be in the future",
+		})
+	}
+
+	secret, err := utils.NewToken()
+	if err != nil {
+		return utils.InternalServerError(c, "could not create token", err)
+	}
+	raw := models.PersonalAccessTokenPrefix + secret
+
+	slices.Sort(body.Scopes)
+	token := models.PersonalAccessToken{
+		UserID:    userID,
+		Name:      body.Name,
+		TokenHash: utils.HashToken(raw),
+		Hint:      raw[:len(models.PersonalAccessTokenPrefix)+4],
+		Scopes:    strings.Join(slices.Compact(body.Scopes), " "),
+		ExpiresAt: body.ExpiresAt,
+	}
+	if err := db.Create(&token).Error; err != nil {
+		return utils.InternalServerError(c, "could not create token", err)
+	}
+
+	response := personalAccessTokenResponse(token)
+	response.Token = raw
+
+	return c.JSON(http.StatusCreated, dto.Response{
+		Message: "token created, copy it now as it will not be shown again",
+		Data:    response,
+	})
+}
+
+// GetPersonalAccessTokens godoc
+// @Summary List personal access tokens
+// @Description List the personal access tokens of the authenticated user, without their values
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} dto.Response{data=[]dto.PersonalAccessTokenResponse}
+// @Failure 500 {object} map[string]string
+// @Router /auth/tokens [get]
+func GetPersonalAccessTokens(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	userID := utils.GetUserID(c)
+	var tokens []models.PersonalAccessToken
+
+	if err := db.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error; err != nil {
+		return utils.InternalServerError(c, "could not retrieve tokens", err)
+	}
+
+	response := make([]dto.PersonalAccessTokenResponse, 0, len(tokens))
+	for _, token := range tokens {
+		response = append(response, personalAccessTokenResponse(token))
+	}
+
+	return c.JSON(http.StatusOK, dto.Response{
+		Message: "success",
+		Data:    response,
+	})
+}
+
+// DeletePersonalAccessToken godoc
+// @Summary Revoke a personal access token
+// @Description Delete a personal access token of the authenticated user
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Token ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/tokens/{id} [delete]
+func DeletePersonalAccessToken(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	userID := utils.GetUserID(c)
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": "token not found",
+		})
+	}
+	result := db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.PersonalAccessToken{})
+	if result.Error != nil {
+		return utils.InternalServerError(c, "could not revoke token", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": "token not found",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "token revoked",
+	})
+}
+
+func personalAccessTokenResponse(token models.PersonalAccessToken) dto.PersonalAccessTokenResponse {
+	return dto.PersonalAccessTokenResponse{
+		ID:         token.ID,
+		Name:       token.Name,
+		Hint:       token.Hint,
+		Scopes:     token.ScopeList(),
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+		CreatedAt:  token.CreatedAt,
+	}
+}
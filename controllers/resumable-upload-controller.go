@@ -0,0 +1,417 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"todo-app/config"
+	"todo-app/models"
+	"todo-app/models/dto"
+	dtoImage "todo-app/models/dto/dto-image"
+	"todo-app/upload"
+	"todo-app/utils"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxActiveUploads bounds the unfinished resumable uploads of a user, whose
+// chunks are stored before the quota applies.
+const maxActiveUploads = 10
+
+// uploadOffsetHeader carries the offset of a chunk, as in the tus protocol.
+const uploadOffsetHeader = "Upload-Offset"
+
+var (
+	errUploadNotFound = errors.New("upload not found or expired")
+	errOffsetMismatch = errors.New("chunk does not start at the upload offset")
+	errChunkOverflow  = errors.New("chunk exceeds the announced size")
+	errCompleting     = errors.New("upload is being completed")
+)
+
+// CreateResumableUpload godoc
+// @Summary Start a resumable upload
+// @Description Start uploading a large file for a task in chunks. Send the chunks in order with PATCH /uploads/{id}, then complete the upload to turn it into an attachment of the task. Unfinished uploads expire after 24 hours without a chunk by default.
+// @Tags images
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param task_id path string true "Task ID"
+// @Param upload body dtoImage.ResumableUploadRequest true "File"
+// @Success 201 {object} dto.Response{data=dtoImage.ResumableUploadResponse}
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 413 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /tasks/{task_id}/uploads [post]
+func CreateResumableUpload(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	userID := utils.GetUserID(c)
+	var body dtoImage.ResumableUploadRequest
+
+	if err := c.Bind(&body); err != nil || strings.TrimSpace(body.Filename) == "" || body.Size <= 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "filename and a positive size are required",
+		})
+	}
+	if limit := config.Cfg.Upload.LargestSize(); body.Size > limit {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{
+			"message": fmt.Sprintf("file exceeds %d bytes limit", limit),
+		})
+	}
+
+	task, _, err := findTask(db, c.Param("task_id"), userID, accessEdit)
+	if err != nil {
+		return taskAccessError(c, err)
+	}
+
+	var active int64
+	err = db.Model(&models.ResumableUpload{}).Where("user_id = ? AND expires_at > ?", userID, time.Now()).Count(&active).Error
+	if err != nil {
+		return utils.InternalServerError(c, "could not start upload", err)
+	}
+	if active >= maxActiveUploads {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"message": fmt.Sprintf("at most %d uploads can be in progress, complete or delete one first", maxActiveUploads),
+		})
+	}
+
+	resumable := models.ResumableUpload{
+		UserID:    userID,
+		TaskID:    task.ID,
+		Filename:  strings.TrimSpace(body.Filename),
+		Size:      body.Size,
+		ExpiresAt: time.Now().Add(config.Cfg.Upload.ResumableExpiry),
+	}
+	if err := db.Create(&resumable).Error; err != nil {
+		return utils.InternalServerError(c, "could not start upload", err)
+	}
+
+	c.Response().Header().Set(uploadOffsetHeader, "0")
+	return c.JSON(http.StatusCreated, dto.Response{
+		Message: "upload started",
+		Data:    resumableUploadResponse(resumable),
+	})
+}
+
+// GetResumableUpload godoc
+// @Summary Get the progress of a resumable upload
+// @Description Get the offset to resume a resumable upload of the authenticated user from
+// @Tags images
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Upload ID"
+// @Success 200 {object} dto.Response{data=dtoImage.ResumableUploadResponse}
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /uploads/{id} [get]
+func GetResumableUpload(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+
+	resumable, err := findResumableUpload(db, c)
+	if err != nil {
+		return resumableUploadError(c, err)
+	}
+
+	c.Response().Header().Set(uploadOffsetHeader, strconv.FormatInt(resumable.Received, 10))
+	return c.JSON(http.StatusOK, dto.Response{Message: "success", Data: resumableUploadResponse(resumable)})
+}
+
+// AppendResumableUpload godoc
+// @Summary Send a chunk of a resumable upload
+// @Description Append the raw request body to a resumable upload. The Upload-Offset header must equal the number of bytes received so far; after a failure, get the upload to find where to resume. Chunks are limited to 5 MB by default.
+// @Tags images
+// @Accept application/octet-stream
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Upload ID"
+// @Param Upload-Offset header int true "Offset of the chunk"
+// @Success 200 {object} dto.Response{data=dtoImage.ResumableUploadResponse}
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 413 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /uploads/{id} [patch]
+func AppendResumableUpload(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	var resumable models.ResumableUpload
+
+	offset, err := strconv.ParseInt(c.Request().Header.Get(uploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "the Upload-Offset header is required",
+		})
+	}
+
+	chunkSize := config.Cfg.Upload.ChunkSize
+	data, err := io.ReadAll(http.MaxBytesReader(c.Response(), c.Request().Body, chunkSize))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{
+			"message": fmt.Sprintf("chunk exceeds %d bytes limit", chunkSize),
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "could not read chunk",
+		})
+	}
+	if len(data) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "chunk is empty",
+		})
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		resumable, err = findResumableUpload(tx.Clauses(clause.Locking{Strength: "UPDATE"}), c)
+		if err != nil {
+			return err
+		}
+		if resumable.Completing {
+			return errCompleting
+		}
+		if offset != resumable.Received {
+			return errOffsetMismatch
+		}
+		if offset+int64(len(data)) > resumable.Size {
+			return errChunkOverflow
+		}
+
+		chunk := models.ResumableUploadChunk{UploadID: resumable.ID, Offset: offset, Data: data}
+		if err := tx.Create(&chunk).Error; err != nil {
+			return err
+		}
+		resumable.Received += int64(len(data))
+		resumable.ExpiresAt = time.Now().Add(config.Cfg.Upload.ResumableExpiry)
+		return tx.Model(&resumable).Select("received", "expires_at").Updates(&resumable).Error
+	})
+	if err != nil {
+		return resumableUploadError(c, err)
+	}
+
+	c.Response().Header().Set(uploadOffsetHeader, strconv.FormatInt(resumable.Received, 10))
+	return c.JSON(http.StatusOK, dto.Response{Message: "chunk received", Data: resumableUploadResponse(resumable)})
+}
+
+// CompleteResumableUpload godoc
+// @Summary Complete a resumable upload
+// @Description Assemble the chunks of a fully received upload into an attachment of its task. The file is checked as a single upload would be, and the upload is deleted whether it is accepted or rejected. While an upload is being completed, further requests to complete, append to or abort it are refused.
+// @Tags images
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Upload ID"
+// @Success 200 {object} dto.Response{data=dtoImage.ImageResponse}
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 413 {object} map[string]string
+// @Failure 415 {object} map[string]string
+// @Failure 422 {object} map[string]string "File is infected"
+// @Failure 500 {object} map[string]string
+// @Router /uploads/{id}/complete [post]
+func CompleteResumableUpload(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+
+	// The upload is claimed under a row lock, so that concurrent requests
+	// cannot assemble it twice.
+	var resumable models.ResumableUpload
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		resumable, err = findResumableUpload(tx.Clauses(clause.Locking{Strength: "UPDATE"}), c)
+		if err != nil {
+			return err
+		}
+		if resumable.Completing {
+			return errCompleting
+		}
+		if resumable.Received < resumable.Size {
+			return nil
+		}
+		resumable.Completing = true
+		return tx.Model(&resumable).Update("completing", true).Error
+	})
+	if err != nil {
+		return resumableUploadError(c, err)
+	}
+	if !resumable.Completing {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"message": fmt.Sprintf("upload is incomplete, %d of %d bytes received", resumable.Received, resumable.Size),
+		})
+	}
+
+	task, _, err := findTask(db, resumable.TaskID, resumable.UserID, accessEdit)
+	if err != nil {
+		releaseResumableUpload(db, c, resumable)
+		return taskAccessError(c, err)
+	}
+
+	reader := &chunkReader{db: db, uploadID: resumable.ID}
+	file, err := upload.Read(reader, resumable.Filename, config.Cfg.Upload.AllowedTypes, config.Cfg.Upload.MaxSize)
+	var image models.Image
+	if err == nil {
+		image, err = storeUpload(c, db, task, file)
+	}
+	if err != nil {
+		if _, _, ok := uploadFailure(file, err); !ok {
+			releaseResumableUpload(db, c, resumable)
+			return uploadError(c, file, err)
+		}
+	}
+
+	// Rejected files will not become acceptable by trying again, so the
+	// upload is deleted either way.
+	if deleteErr := db.Delete(&resumable).Error; deleteErr != nil {
+		return utils.InternalServerError(c, "could not delete upload", deleteErr)
+	}
+	if err != nil {
+		return uploadError(c, file, err)
+	}
+
+	return c.JSON(http.StatusOK, dto.Response{
+		Message: "image upload successfully",
+		Data:    imageResponse(image, imageURL(image)),
+	})
+}
+
+// DeleteResumableUpload godoc
+// @Summary Abort a resumable upload
+// @Description Delete an unfinished resumable upload of the authenticated user and its chunks
+// @Tags images
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Upload ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /uploads/{id} [delete]
+func DeleteResumableUpload(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+
+	// Uploads being completed are left to the completing request, whose
+	// chunks would otherwise be cut short.
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": errUploadNotFound.Error(),
+		})
+	}
+	result := db.Where("id = ? AND user_id = ? AND NOT completing", id, utils.GetUserID(c)).Delete(&models.ResumableUpload{})
+	if result.Error != nil {
+		return utils.InternalServerError(c, "could not delete upload", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": errUploadNotFound.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "upload deleted",
+	})
+}
+
+// PurgeExpiredUploads deletes resumable uploads that have not been completed
+// in time, together with their chunks.
+func PurgeExpiredUploads(ctx context.Context) error {
+	return config.DB.WithContext(ctx).
+		Where("expires_at <= ?", time.Now()).
+		Delete(&models.ResumableUpload{}).Error
+}
+
+// findResumableUpload returns the unexpired upload in the id parameter, if it
+// belongs to the authenticated user.
+func findResumableUpload(db *gorm.DB, c echo.Context) (models.ResumableUpload, error) {
+	var resumable models.ResumableUpload
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return resumable, errUploadNotFound
+	}
+	err = db.Where("id = ? AND user_id = ? AND expires_at > ?", id, utils.GetUserID(c), time.Now()).
+		First(&resumable).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return resumable, errUploadNotFound
+	}
+
+	return resumable, err
+}
+
+// releaseResumableUpload gives up the claim on resumable after a failure that
+// is not the client's, so that completing it can be retried.
+func releaseResumableUpload(db *gorm.DB, c echo.Context, resumable models.ResumableUpload) {
+	if err := db.Model(&resumable).Update("completing", false).Error; err != nil {
+		slog.ErrorContext(c.Request().Context(), "could not release upload", "upload_id", resumable.ID, "error", err)
+	}
+}
+
+// resumableUploadError answers a failed resumable upload request.
+func resumableUploadError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, errUploadNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": err.Error(),
+		})
+	case errors.Is(err, errOffsetMismatch), errors.Is(err, errCompleting):
+		return c.JSON(http.StatusConflict, map[string]string{
+			"message": err.Error(),
+		})
+	case errors.Is(err, errChunkOverflow):
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{
+			"message": err.Error(),
+		})
+	default:
+		return utils.InternalServerError(c, "could not store chunk", err)
+	}
+}
+
+// chunkReader reads the chunks of an upload in order, one at a time, so
+// that only one is held in memory besides the assembled file.
+type chunkReader struct {
+	db       *gorm.DB
+	uploadID uint
+	offset   int64
+	buf      []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		var chunk models.ResumableUploadChunk
+		err := r.db.Where("upload_id = ? AND byte_offset = ?", r.uploadID, r.offset).First(&chunk).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		r.buf = chunk.Data
+		r.offset += int64(len(chunk.Data))
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func resumableUploadResponse(resumable models.ResumableUpload) dtoImage.ResumableUploadResponse {
+	return dtoImage.ResumableUploadResponse{
+		ID:        resumable.ID,
+		TaskID:    resumable.TaskID,
+		Filename:  resumable.Filename,
+		Size:      resumable.Size,
+		Offset:    resumable.Received,
+		ChunkSize: config.Cfg.Upload.ChunkSize,
+		ExpiresAt: resumable.ExpiresAt,
+	}
+}
@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+	"todo-app/config"
+	"todo-app/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+var resumableUploadColumns = []string{"id", "user_id", "task_id", "filename", "size", "received", "completing", "expires_at"}
+
+// useUploadDB points config.DB at a fake database holding upload 3 of user 1,
+// a 10 bytes file of which received bytes were sent.
+func useUploadDB(t *testing.T, received int64, completing bool) *fakeDB {
+	t.Helper()
+	db, fake := fakeGormDB(t, map[string]fakeResult{
+		`SELECT * FROM "resumable_uploads"`: {resumableUploadColumns, [][]driver.Value{
+			{int64(3), int64(1), int64(5), "notes.txt", int64(10), received, completing, time.Now().Add(time.Hour)},
+		}},
+	})
+
+	previous := config.DB
+	config.DB = db
+	t.Cleanup(func() { config.DB = previous })
+	return fake
+}
+
+// uploadContext returns a request of user 1 for upload 3.
+func uploadContext(method string, body io.Reader) (echo.Context, *httptest.ResponseRecorder) {
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(method, "/api/uploads/3", body), rec)
+	c.SetParamNames("id")
+	c.SetParamValues("3")
+	c.Set("user", &jwt.Token{Valid: true, Claims: &utils.Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "1"}}})
+	return c, rec
+}
+
+func TestAppendResumableUpload(t *testing.T) {
+	chunkSize := config.Cfg.Upload.ChunkSize
+	config.Cfg.Upload.ChunkSize = 8
+	t.Cleanup(func() { config.Cfg.Upload.ChunkSize = chunkSize })
+
+	tests := []struct {
+		name       string
+		completing bool
+		offset     string
+		chunk      string
+		status     int
+	}{
+		{"next chunk", false, "4", "efghij", http.StatusOK},
+		{"offset behind", false, "0", "abcd", http.StatusConflict},
+		{"offset ahead", false, "6", "gh", http.StatusConflict},
+		{"past the announced size", false, "4", "efghijk", http.StatusRequestEntityTooLarge},
+		{"over the chunk size", false, "4", "efghijklm", http.StatusRequestEntityTooLarge},
+		{"being completed", true, "4", "efgh", http.StatusConflict},
+		{"no offset", false, "", "efgh", http.StatusBadRequest},
+		{"empty chunk", false, "4", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := useUploadDB(t, 4, tt.completing)
+			c, rec := uploadContext(http.MethodPatch, strings.NewReader(tt.chunk))
+			if tt.offset != "" {
+				c.Request().Header.Set(uploadOffsetHeader, tt.offset)
+			}
+
+			if err := AppendResumableUpload(c); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+
+			stored := fake.ran(`INSERT INTO "resumable_upload_chunks"`)
+			if stored != (tt.status == http.StatusOK) {
+				t.Errorf("chunk stored = %v with status %d", stored, rec.Code)
+			}
+			if tt.status == http.StatusOK {
+				if offset := rec.Header().Get(uploadOffsetHeader); offset != "10" {
+					t.Errorf("%s = %s, want 10", uploadOffsetHeader, offset)
+				}
+			}
+		})
+	}
+}
+
+func TestChunkReader(t *testing.T) {
+	content := []byte("the quick brown fox jumps over the lazy dog")
+	chunks := map[int64][]byte{}
+	for offset, size := 0, 1; offset < len(content); offset, size = offset+size, size*2 {
+		end := min(offset+size, len(content))
+		chunks[int64(offset)] = content[offset:end]
+	}
+
+	for name, read := range map[string]func(io.Reader) ([]byte, error){
+		"whole":    io.ReadAll,
+		"one byte": func(r io.Reader) ([]byte, error) { return io.ReadAll(iotest.OneByteReader(r)) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			db, fake := fakeGormDB(t, nil)
+			fake.answer = func(query string, args []driver.Value) *fakeResult {
+				if !strings.HasPrefix(query, `SELECT * FROM "resumable_upload_chunks"`) || args[0] != int64(3) {
+					return nil
+				}
+				data, ok := chunks[args[1].(int64)]
+				if !ok {
+					return nil
+				}
+				return &fakeResult{[]string{"id", "upload_id", "byte_offset", "data"}, [][]driver.Value{{int64(1), int64(3), args[1], data}}}
+			}
+
+			got, err := read(&chunkReader{db: db, uploadID: 3})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("read %q, want %q", got, content)
+			}
+			if queries := len(fake.argsOf(`SELECT * FROM "resumable_upload_chunks"`)); queries != len(chunks)+1 {
+				t.Errorf("%d chunk queries, want one per chunk and one for the end", queries)
+			}
+		})
+	}
+}
+
+func TestCompleteResumableUploadClaim(t *testing.T) {
+	t.Run("incomplete", func(t *testing.T) {
+		fake := useUploadDB(t, 4, false)
+		c, rec := uploadContext(http.MethodPost, nil)
+
+		if err := CompleteResumableUpload(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusConflict {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusConflict)
+		}
+		if fake.ran(`UPDATE "resumable_uploads"`) {
+			t.Error("incomplete upload claimed")
+		}
+	})
+
+	t.Run("claimed by another request", func(t *testing.T) {
+		fake := useUploadDB(t, 10, true)
+		c, rec := uploadContext(http.MethodPost, nil)
+
+		if err := CompleteResumableUpload(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusConflict {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusConflict)
+		}
+		if fake.ran(`UPDATE "resumable_uploads"`) || fake.ran(`DELETE FROM "resumable_uploads"`) {
+			t.Errorf("upload of another request changed: %q", fake.statements)
+		}
+	})
+
+	t.Run("released after a failure", func(t *testing.T) {
+		// The task is not found, which is not the fault of the upload, so
+		// the claim is given up for completing to be retried.
+		fake := useUploadDB(t, 10, false)
+		c, rec := uploadContext(http.MethodPost, nil)
+
+		if err := CompleteResumableUpload(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+		}
+
+		claims := fake.argsOf(`UPDATE "resumable_uploads" SET "completing"=$1`)
+		if len(claims) != 2 || claims[0][0] != true || claims[1][0] != false {
+			t.Errorf("completing set to %v, want claimed then released", claims)
+		}
+		if !fake.ran(`SELECT * FROM "resumable_uploads" WHERE id = $1 AND user_id = $2 AND expires_at > $3 ORDER BY "resumable_uploads"."id" LIMIT $4 FOR UPDATE`) {
+			t.Errorf("upload claimed without a row lock: %q", fake.statements)
+		}
+		if fake.ran(`DELETE FROM "resumable_uploads"`) {
+			t.Error("upload deleted")
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"strconv"
+	"time"
+	"todo-app/config"
+	"todo-app/models"
+	"todo-app/signing"
+	"todo-app/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// issueAccessToken starts a new session for user and returns its signed
+// access token.
+func issueAccessToken(db *gorm.DB, user models.User) (string, error) {
+	sessionID, err := utils.NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(config.Cfg.Auth.AccessTokenTTL)
+	if err := db.Create(&models.Session{
+		ID:        sessionID,
+		UserID:    user.ID,
+		ExpiresAt: expiresAt,
+	}).Error; err != nil {
+		return "", err
+	}
+
+	claims := utils.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    config.Cfg.Auth.JWTIssuer,
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			Audience:  jwt.ClaimStrings{config.Cfg.Auth.JWTAudience},
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+		SessionID: sessionID,
+	}
+	return signing.Sign(db.Statement.Context, claims)
+}
+
+// revokeSessions logs the user out everywhere except the session keepID,
+// which may be empty.
+func revokeSessions(db *gorm.DB, userID uint, keepID string) error {
+	return db.Model(&models.Session{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, keepID).
+		Update("revoked_at", time.Now()).Error
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"todo-app/models"
+	"todo-app/utils"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// taskAccess is what a user may do with a task, in increasing order.
+type taskAccess int
+
+const (
+	accessNone taskAccess = iota
+	accessView
+	accessEdit
+	accessOwner
+)
+
+var (
+	// errTaskNotFound is returned for tasks that do not exist and for tasks
+	// the user cannot see, so that task IDs cannot be probed.
+	errTaskNotFound  = errors.New("task not found")
+	errTaskForbidden = errors.New("you do not have permission to do this with the task")
+	errInvalidTaskID = errors.New("invalid task id")
+)
+
+// findTask loads a task the user has at least the required access to.
+// taskID is an ID or a route parameter holding one, which is parsed here so
+// that malformed IDs are rejected before they reach the database.
+func findTask(db *gorm.DB, taskID interface{}, userID uint, required taskAccess) (models.Task, taskAccess, error) {
+	var task models.Task
+
+	if param, ok := taskID.(string); ok {
+		id, err := strconv.ParseUint(param, 10, 64)
+		if err != nil {
+			return task, accessNone, errInvalidTaskID
+		}
+		taskID = id
+	}
+
+	err := db.Where("id = ?", taskID).First(&task).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return task, accessNone, errTaskNotFound
+	}
+	if err != nil {
+		return task, accessNone, err
+	}
+
+	var share *models.TaskShare
+	if task.UserID != userID {
+		share = &models.TaskShare{}
+		err := db.Where("task_id = ? AND user_id = ?", task.ID, userID).First(share).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			share = nil
+		} else if err != nil {
+			return task, accessNone, err
+		}
+	}
+
+	access := accessTo(task, userID, share)
+	return task, access, access.allows(required)
+}
+
+// accessTo returns the access of a user to task, given their share of it if
+// they have one.
+func accessTo(task models.Task, userID uint, share *models.TaskShare) taskAccess {
+	switch {
+	case task.UserID == userID:
+		return accessOwner
+	case share == nil:
+		return accessNone
+	case share.Permission == models.SharePermissionEdit:
+		return accessEdit
+	default:
+		return accessView
+	}
+}
+
+// allows checks access against the required access. Users without any
+// access are told the task does not exist.
+func (a taskAccess) allows(required taskAccess) error {
+	if a == accessNone {
+		return errTaskNotFound
+	}
+	if a < required {
+		return errTaskForbidden
+	}
+	return nil
+}
+
+// taskAccessError answers a failed findTask.
+func taskAccessError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, errInvalidTaskID):
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	case errors.Is(err, errTaskNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": err.Error(),
+		})
+	case errors.Is(err, errTaskForbidden):
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"message": err.Error(),
+		})
+	default:
+		return utils.InternalServerError(c, "could not retrieve task", err)
+	}
+}
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"todo-app/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestAccessTo(t *testing.T) {
+	task := models.Task{ID: 1, UserID: 10}
+
+	tests := []struct {
+		name   string
+		userID uint
+		share  *models.TaskShare
+		want   taskAccess
+	}{
+		{"owner", 10, nil, accessOwner},
+		{"owner with a stray share", 10, &models.TaskShare{Permission: models.SharePermissionView}, accessOwner},
+		{"view share", 20, &models.TaskShare{Permission: models.SharePermissionView}, accessView},
+		{"edit share", 20, &models.TaskShare{Permission: models.SharePermissionEdit}, accessEdit},
+		{"unknown permission", 20, &models.TaskShare{Permission: "admin"}, accessView},
+		{"no share", 20, nil, accessNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := accessTo(task, tt.userID, tt.share); got != tt.want {
+				t.Errorf("access = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTaskAccessAllows(t *testing.T) {
+	tests := []struct {
+		access   taskAccess
+		required taskAccess
+		want     error
+	}{
+		{accessNone, accessView, errTaskNotFound},
+		{accessView, accessView, nil},
+		{accessView, accessEdit, errTaskForbidden},
+		{accessView, accessOwner, errTaskForbidden},
+		{accessEdit, accessEdit, nil},
+		{accessEdit, accessOwner, errTaskForbidden},
+		{accessOwner, accessOwner, nil},
+	}
+	for _, tt := range tests {
+		if err := tt.access.allows(tt.required); !errors.Is(err, tt.want) {
+			t.Errorf("access %d with %d required: error = %v, want %v", tt.access, tt.required, err, tt.want)
+		}
+	}
+}
+
+func TestFindTaskRejectsMalformedIDs(t *testing.T) {
+	// The database is not reached for malformed IDs.
+	for _, id := range []string{"", "abc", "1abc", "-1", "1.5", "99999999999999999999"} {
+		if _, _, err := findTask(nil, id, 1, accessView); !errors.Is(err, errInvalidTaskID) {
+			t.Errorf("findTask(%q) error = %v, want %v", id, err, errInvalidTaskID)
+		}
+	}
+}
+
+func TestTaskAccessError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{errInvalidTaskID, http.StatusBadRequest},
+		{errTaskNotFound, http.StatusNotFound},
+		{errTaskForbidden, http.StatusForbidden},
+		{errors.New("connection refused"), http.StatusInternalServerError},
+	}
+
+	e := echo.New()
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/api/tasks/1", nil), rec)
+		if err := taskAccessError(c, tt.err); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != tt.status {
+			t.Errorf("%v: status = %d, want %d", tt.err, rec.Code, tt.status)
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package controllers
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
 	"todo-app/config"
+	"todo-app/metrics"
 	"todo-app/models"
 	"todo-app/models/dto"
 	dtoImage "todo-app/models/dto/dto-image"
@@ -26,6 +28,7 @@ import (
 // @Failure 500 {object} map[string]string
 // @Router /tasks [post]
 func CreateTask(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
 	userID := utils.GetUserID(c)
 	var task models.Task
 	var taskRequest dto.TaskRequest
@@ -41,10 +44,13 @@ func CreateTask(c echo.Context) error {
 	task.Description = taskRequest.Description
 	task.Completed = taskRequest.Completed
 
-	if err := config.DB.Create(&task).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"message": "could not create task",
-		})
+	if err := db.Create(&task).Error; err != nil {
+		return utils.InternalServerError(c, "could not create task", err)
+	}
+
+	metrics.TasksCreatedTotal.Inc()
+	if task.Completed {
+		metrics.TasksCompletedTotal.Inc()
 	}
 
 	return c.JSON(http.StatusCreated, dto.Response{Message: "task created", Data: dto.TaskResponse{
@@ -69,10 +75,11 @@ func CreateTask(c echo.Context) error {
 // @Failure 500 {object} map[string]string "Internal server error"
 // @Router /tasks [get]
 func GetTasks(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
 	userID := utils.GetUserID(c)
 	var tasks []models.Task
 
-	query := config.DB.Where("user_id = ?", userID)
+	query := db.Where("user_id = ?", userID)
 
 	completedParam := c.QueryParam("completed")
 	if completedParam != "" {
@@ -85,33 +92,13 @@ func GetTasks(c echo.Context) error {
 		query = query.Where("completed = ?", completed)
 	}
 
-	if err := query.Order("id").Preload("Images").Find(&tasks).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"message": "could not retrieve tasks",
-		})
+	if err := query.Order("id").Preload("Images", orderImages).Find(&tasks).Error; err != nil {
+		return utils.InternalServerError(c, "could not retrieve tasks", err)
 	}
 
 	taskResponses := []dto.TaskResponse{}
 	for _, task := range tasks {
-		var imageResponses []dtoImage.ImageResponse
-		for _, image := range task.Images {
-			imageResponses = append(imageResponses, dtoImage.ImageResponse{
-				ID:          image.ID,
-				Filename:    image.Filename,
-				ContentType: image.ContentType,
-				CreatedAt:   image.CreatedAt,
-			})
-		}
-
-		taskResponses = append(taskResponses, dto.TaskResponse{
-			ID:          task.ID,
-			Title:       task.Title,
-			Description: task.Description,
-			Images:      imageResponses,
-			Completed:   task.Completed,
-			CreatedAt:   task.CreatedAt,
-			UpdatedAt:   task.UpdatedAt,
-		})
+		taskResponses = append(taskResponses, taskResponse(task))
 	}
 
 	return c.JSON(http.StatusOK, dto.Response{Message: "task retrived", Data: taskResponses})
@@ -119,7 +106,7 @@ func GetTasks(c echo.Context) error {
 
 // GetTaskById godoc
 // @Summary Get a task by ID
-// @Description Get a task by ID for the authenticated user
+// @Description Get a task by ID that the authenticated user owns or that has been shared with them
 // @Tags tasks
 // @Produce json
 // @Security BearerAuth
@@ -129,48 +116,27 @@ func GetTasks(c echo.Context) error {
 // @Failure 500 {object} map[string]string
 // @Router /tasks/{id} [get]
 func GetTaskById(c echo.Context) error {
-	var task models.Task
+	db := config.DB.WithContext(c.Request().Context())
 	userID := utils.GetUserID(c)
 	id := c.Param("id")
 
-	if err := config.DB.Where("user_id = ? AND id = ?", userID, id).Preload("Images").First(&task).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return c.JSON(http.StatusNotFound, map[string]string{
-				"message": "task not found",
-			})
-		}
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"message": "could not retrieve task",
-		})
+	task, _, err := findTask(db, id, userID, accessView)
+	if err != nil {
+		return taskAccessError(c, err)
 	}
-
-	imageResponse := []dtoImage.ImageResponse{}
-	for _, image := range task.Images {
-		imageResponse = append(imageResponse, dtoImage.ImageResponse{
-			ID:          image.ID,
-			Filename:    image.Filename,
-			ContentType: image.ContentType,
-			CreatedAt:   image.CreatedAt,
-		})
+	if err := db.Scopes(orderImages).Where("task_id = ?", task.ID).Find(&task.Images).Error; err != nil {
+		return utils.InternalServerError(c, "could not retrieve task", err)
 	}
 
 	return c.JSON(http.StatusOK, dto.Response{
 		Message: "success",
-		Data: dto.TaskResponse{
-			ID:          task.ID,
-			Title:       task.Title,
-			Description: task.Description,
-			Images:      imageResponse,
-			Completed:   task.Completed,
-			CreatedAt:   task.CreatedAt,
-			UpdatedAt:   task.UpdatedAt,
-		},
+		Data:    taskResponse(task),
 	})
 }
 
 // UpdateTaskById godoc
 // @Summary Update a task by ID
-// @Description Update a task by ID for the authenticated user
+// @Description Update a task by ID that the authenticated user owns or can edit through a share
 // @Tags tasks
 // @Accept json
 // @Produce json
@@ -181,11 +147,12 @@ func GetTaskById(c echo.Context) error {
 // @Failure 400 {object} map[string]string
 // @Failure 404 {object} map[string]string
 // @Failure 500 {object} map[string]string
+// @Failure 403 {object} map[string]string
 // @Router /tasks/{id} [patch]
 func UpdateTaskById(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
 	userID := utils.GetUserID(c)
 	id := c.Param("id")
-	var task models.Task
 	var updatedTask dto.TaskRequest
 
 	if err := c.Bind(&updatedTask); err != nil {
@@ -194,21 +161,18 @@ func UpdateTaskById(c echo.Context) error {
 		})
 	}
 
-	if err := config.DB.Where("user_id = ? AND id = ?", userID, id).First(&task).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return c.JSON(http.StatusNotFound, map[string]string{
-				"message": "task not found",
-			})
-		}
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"message": "could not retrieve task",
-		})
+	task, _, err := findTask(db, id, userID, accessEdit)
+	if err != nil {
+		return taskAccessError(c, err)
 	}
 
-	if err := config.DB.Model(&task).Updates(updatedTask).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"message": "Could not update task",
-		})
+	wasCompleted := task.Completed
+	if err := db.Model(&task).Updates(updatedTask).Error; err != nil {
+		return utils.InternalServerError(c, "Could not update task", err)
+	}
+
+	if !wasCompleted && updatedTask.Completed {
+		metrics.TasksCompletedTotal.Inc()
 	}
 
 	return c.JSON(http.StatusOK, dto.Response{
@@ -226,7 +190,7 @@ func UpdateTaskById(c echo.Context) error {
 
 // DeleteTaskById godoc
 // @Summary Delete a task by ID
-// @Description Delete a task by ID for the authenticated user
+// @Description Delete a task by ID owned by the authenticated user
 // @Tags tasks
 // @Produce json
 // @Security BearerAuth
@@ -234,27 +198,23 @@ func UpdateTaskById(c echo.Context) error {
 // @Success 200 {object} dto.Response
 // @Failure 404 {object} map[string]string
 // @Failure 500 {object} map[string]string
+// @Failure 403 {object} map[string]string
 // @Router /tasks/{id} [delete]
 func DeleteTaskById(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
 	userID := utils.GetUserID(c)
 	id := c.Param("id")
-	var task models.Task
 
-	if err := config.DB.Where("user_id = ? AND id = ?", userID, id).First(&task).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return c.JSON(http.StatusNotFound, map[string]string{
-				"message": "Task not found",
-			})
-		}
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"message": "Could not retrieve task",
-		})
+	task, _, err := findTask(db, id, userID, accessOwner)
+	if err != nil {
+		return taskAccessError(c, err)
 	}
 
-	if err := config.DB.Delete(&task).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"message": "Could not delete task",
-		})
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return deleteTask(tx, task)
+	})
+	if err != nil {
+		return utils.InternalServerError(c, "Could not delete task", err)
 	}
 
 	return c.JSON(http.StatusOK, dto.Response{
@@ -269,3 +229,71 @@ func DeleteTaskById(c echo.Context) error {
 		},
 	})
 }
+
+// deleteTask deletes task with the rows that refer to it. They are deleted
+// first, as their foreign keys do not cascade in databases created by older
+// versions.
+func deleteTask(tx *gorm.DB, task models.Task) error {
+	for _, model := range []interface{}{&models.TaskShare{}, &models.TaskLink{}, &models.ResumableUpload{}, &models.Image{}} {
+		if err := tx.Where("task_id = ?", task.ID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Delete(&task).Error
+}
+
+// taskResponse converts a task with its images preloaded.
+func taskResponse(task models.Task) dto.TaskResponse {
+	imageResponses := []dtoImage.ImageResponse{}
+	var coverImageID *uint
+	for _, image := range task.Images {
+		imageResponses = append(imageResponses, imageResponse(image, imageURL(image)))
+		if image.Cover {
+			coverImageID = &image.ID
+		}
+	}
+
+	return dto.TaskResponse{
+		ID:           task.ID,
+		Title:        task.Title,
+		Description:  task.Description,
+		Images:       imageResponses,
+		CoverImageID: coverImageID,
+		Completed:    task.Completed,
+		CreatedAt:    task.CreatedAt,
+		UpdatedAt:    task.UpdatedAt,
+	}
+}
+
+// imageResponse describes image, served at url.
+func imageResponse(image models.Image, url string) dtoImage.ImageResponse {
+	var variants map[string]string
+	if image.IsImage() {
+		variants = make(map[string]string, len(config.Cfg.Upload.Variants))
+		for _, variant := range config.Cfg.Upload.Variants {
+			variants[variant.Name] = url + "?variant=" + variant.Name
+		}
+	}
+
+	return dtoImage.ImageResponse{
+		ID:          image.ID,
+		Filename:    image.Filename,
+		ContentType: image.ContentType,
+		Size:        image.Size,
+		SHA256:      image.SHA256,
+		Width:       image.Width,
+		Height:      image.Height,
+		URL:         url,
+		Position:    image.Position,
+		Caption:     image.Caption,
+		AltText:     image.AltText,
+		Cover:       image.Cover,
+		Variants:    variants,
+		ScanStatus:  image.ScanStatus,
+		CreatedAt:   image.CreatedAt,
+	}
+}
+
+func imageURL(image models.Image) string {
+	return fmt.Sprintf("/api/images/%d", image.ID)
+}
@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+	"todo-app/models"
+)
+
+func TestDeleteTaskDeletesReferringRowsFirst(t *testing.T) {
+	db, recorder := dryRunDB(t)
+
+	if err := deleteTask(db, models.Task{ID: 42, UserID: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Shares, links and attachments would block the delete of the task in
+	// databases whose foreign keys do not cascade.
+	want := []string{
+		`DELETE FROM "task_shares" WHERE task_id = 42`,
+		`DELETE FROM "task_links" WHERE task_id = 42`,
+		`DELETE FROM "resumable_uploads" WHERE task_id = 42`,
+		`DELETE FROM "images" WHERE task_id = 42`,
+		`DELETE FROM "tasks" WHERE "tasks"."id" = 42`,
+	}
+	if len(recorder.statements) != len(want) {
+		t.Fatalf("statements = %q, want %d", recorder.statements, len(want))
+	}
+	for i, statement := range recorder.statements {
+		if !strings.HasPrefix(statement, want[i]) {
+			t.Errorf("statement %d = %s, want %s", i+1, statement, want[i])
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"todo-app/config"
+	"todo-app/models"
+	"todo-app/models/dto"
+	"todo-app/utils"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// CreateTaskLink godoc
+// @Summary Create a public link to a task
+// @Description Create an unguessable read-only link that shows a task owned by the authenticated user, and its images, without logging in. The link is only returned once.
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Task ID"
+// @Param link body dto.TaskLinkRequest false "Link"
+// @Success 201 {object} dto.Response{data=dto.TaskLinkResponse}
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /tasks/{id}/links [post]
+func CreateTaskLink(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	var body dto.TaskLinkRequest
+
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "invalid input",
+		})
+	}
+	if body.ExpiresAt != nil && !body.ExpiresAt.After(time.Now()) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "expires_at must be in the future",
+		})
+	}
+
+	task, _, err := findTask(db, c.Param("id"), utils.GetUserID(c), accessOwner)
+	if err != nil {
+		return taskAccessError(c, err)
+	}
+
+	token, err := utils.NewToken()
+	if err != nil {
+		return utils.InternalServerError(c, "could not create link", err)
+	}
+
+	link := models.TaskLink{
+		TaskID:    task.ID,
+		TokenHash: utils.HashToken(token),
+		Hint:      token[:6],
+		ExpiresAt: body.ExpiresAt,
+	}
+	if err := db.Create(&link).Error; err != nil {
+		return utils.InternalServerError(c, "could not create link", err)
+	}
+
+	response := taskLinkResponse(link)
+	response.Token = token
+	// The Host header is chosen by the client, so the URL is built from the
+	// configured one.
+	response.URL = strings.TrimRight(config.Cfg.Mail.LinkBaseURL, "/") + "/api/public/tasks/" + token
+
+	return c.JSON(http.StatusCreated, dto.Response{
+		Message: "link created, copy it now as it will not be shown again",
+		Data:    response,
+	})
+}
+
+// GetTaskLinks godoc
+// @Summary List the public links of a task
+// @Description List the public links of a task owned by the authenticated user, without their tokens
+// @Tags tasks
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Task ID"
+// @Success 200 {object} dto.Response{data=[]dto.TaskLinkResponse}
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /tasks/{id}/links [get]
+func GetTaskLinks(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	var links []models.TaskLink
+
+	task, _, err := findTask(db, c.Param("id"), utils.GetUserID(c), accessOwner)
+	if err != nil {
+		return taskAccessError(c, err)
+	}
+
+	if err := db.Where("task_id = ?", task.ID).Order("id").Find(&links).Error; err != nil {
+		return utils.InternalServerError(c, "could not retrieve links", err)
+	}
+
+	response := make([]dto.TaskLinkResponse, 0, len(links))
+	for _, link := range links {
+		response = append(response, taskLinkResponse(link))
+	}
+
+	return c.JSON(http.StatusOK, dto.Response{Message: "success", Data: response})
+}
+
+// DeleteTaskLink godoc
+// @Summary Revoke a public link
+// @Description Revoke a public link to a task owned by the authenticated user
+// @Tags tasks
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Task ID"
+// @Param link_id path string true "Link ID"
+// @Success 200 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /tasks/{id}/links/{link_id} [delete]
+func DeleteTaskLink(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+
+	task, _, err := findTask(db, c.Param("id"), utils.GetUserID(c), accessOwner)
+	if err != nil {
+		return taskAccessError(c, err)
+	}
+
+	result := db.Where("id = ? AND task_id = ?", c.Param("link_id"), task.ID).Delete(&models.TaskLink{})
+	if result.Error != nil {
+		return utils.InternalServerError(c, "could not revoke link", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": "link not found",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "link revoked",
+	})
+}
+
+// GetPublicTask godoc
+// @Summary View a task through a public link
+// @Description Get the task a public link points to. Its images are served at /public/tasks/{token}/images/{image_id}.
+// @Tags public
+// @Produce json
+// @Param token path string true "Link token"
+// @Success 200 {object} dto.Response{data=dto.TaskResponse}
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /public/tasks/{token} [get]
+func GetPublicTask(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+
+	task, err := findLinkedTask(db, c.Param("token"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": "link not found or expired",
+		})
+	}
+	if err != nil {
+		return utils.InternalServerError(c, "could not retrieve task", err)
+	}
+
+	if err := db.Scopes(orderImages).Where("task_id = ?", task.ID).Find(&task.Images).Error; err != nil {
+		return utils.InternalServerError(c, "could not retrieve task", err)
+	}
+
+	response := taskResponse(task)
+	for i, image := range task.Images {
+		imageURL := fmt.Sprintf("/api/public/tasks/%s/images/%d", url.PathEscape(c.Param("token")), image.ID)
+		response.Images[i] = imageResponse(image, imageURL)
+	}
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, dto.Response{Message: "success", Data: response})
+}
+
+// GetPublicTaskImage godoc
+// @Summary Get an image through a public link
+// @Description Get an image of the task a public link points to. Accepts the same variant, w and h parameters, and conditional and range requests, as /images/{id}.
+// @Tags public
+// @Produce image/jpeg
+// @Produce image/png
+// @Param token path string true "Link token"
+// @Param image_id path string true "Image ID"
+// @Param variant query string false "Variant name"
+// @Param w query int false "Maximum width in pixels"
+// @Param h query int false "Maximum height in pixels"
+// @Param Range header string false "Byte range, e.g. bytes=0-1023"
+// @Param If-None-Match header string false "ETag of a cached copy"
+// @Success 200 {file} file
+// @Success 206 {file} file
+// @Success 304 "Not modified"
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string "Attachment is still being scanned"
+// @Failure 500 {object} map[string]string
+// @Router /public/tasks/{token}/images/{image_id} [get]
+func GetPublicTaskImage(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	var image models.Image
+
+	imageID, err := strconv.ParseUint(c.Param("image_id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": "image not found",
+		})
+	}
+
+	task, err := findLinkedTask(db, c.Param("token"))
+	if err == nil {
+		err = db.Where("id = ? AND task_id = ?", imageID, task.ID).First(&image).Error
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": "image not found",
+		})
+	}
+	if err != nil {
+		return utils.InternalServerError(c, "could not retrieve image", err)
+	}
+
+	// Revalidate every time, so that revoked links stop working.
+	return serveImage(c, db, image, "no-cache")
+}
+
+// findLinkedTask returns the task of an unexpired public link. Links stop
+// working while the owner is disabled or scheduled for deletion.
+func findLinkedTask(db *gorm.DB, token string) (models.Task, error) {
+	var link models.TaskLink
+
+	err := db.Preload("Task").
+		Joins("JOIN tasks ON tasks.id = task_links.task_id").
+		Joins("JOIN users ON users.id = tasks.user_id").
+		Where("task_links.token_hash = ?", utils.HashToken(token)).
+		Where("task_links.expires_at IS NULL OR task_links.expires_at > ?", time.Now()).
+		Where("users.disabled_at IS NULL AND users.deletion_scheduled_at IS NULL").
+		First(&link).Error
+
+	return link.Task, err
+}
+
+func taskLinkResponse(link models.TaskLink) dto.TaskLinkResponse {
+	return dto.TaskLinkResponse{
+		ID:        link.ID,
+		Hint:      link.Hint,
+		ExpiresAt: link.ExpiresAt,
+		CreatedAt: link.CreatedAt,
+	}
+}
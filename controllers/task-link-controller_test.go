@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"todo-app/utils"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// sqlRecorder is a GORM logger keeping the statements it is given.
+type sqlRecorder struct {
+	statements []string
+}
+
+func (r *sqlRecorder) LogMode(logger.LogLevel) logger.Interface      { return r }
+func (r *sqlRecorder) Info(context.Context, string, ...interface{})  {}
+func (r *sqlRecorder) Warn(context.Context, string, ...interface{})  {}
+func (r *sqlRecorder) Error(context.Context, string, ...interface{}) {}
+
+func (r *sqlRecorder) Trace(_ context.Context, _ time.Time, fc func() (string, int64), _ error) {
+	sql, _ := fc()
+	r.statements = append(r.statements, sql)
+}
+
+// dryRunDB returns a database that builds statements without running them,
+// and the recorder they are logged to.
+func dryRunDB(t *testing.T) (*gorm.DB, *sqlRecorder) {
+	t.Helper()
+	recorder := &sqlRecorder{}
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=127.0.0.1 port=1"}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+		Logger:                 recorder,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, recorder
+}
+
+func TestFindLinkedTaskConditions(t *testing.T) {
+	db, recorder := dryRunDB(t)
+
+	if _, err := findLinkedTask(db, "secret-link-token"); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.statements) == 0 {
+		t.Fatal("no statement built")
+	}
+	sql := recorder.statements[0]
+
+	if strings.Contains(sql, "secret-link-token") {
+		t.Error("link token queried in plain text")
+	}
+	for _, condition := range []string{
+		"task_links.token_hash = '" + utils.HashToken("secret-link-token") + "'",
+		// Without the parentheses, links that never expire would match
+		// regardless of the other conditions.
+		"(task_links.expires_at IS NULL OR task_links.expires_at > ",
+		"users.disabled_at IS NULL AND users.deletion_scheduled_at IS NULL",
+	} {
+		if !strings.Contains(sql, condition) {
+			t.Errorf("query lacks %q:\n%s", condition, sql)
+		}
+	}
+}
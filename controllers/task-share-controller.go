@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"todo-app/config"
+	"todo-app/models"
+	"todo-app/models/dto"
+	"todo-app/utils"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ShareTask godoc
+// @Summary Share a task with a user
+// @Description Give another user view or edit access to a task owned by the authenticated user. Sharing again with the same user changes the permission.
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Task ID"
+// @Param share body dto.TaskShareRequest true "Share"
+// @Success 200 {object} dto.Response{data=dto.TaskShareResponse}
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /tasks/{id}/shares [post]
+func ShareTask(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	userID := utils.GetUserID(c)
+	var body dto.TaskShareRequest
+	var grantee models.User
+
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "invalid input",
+		})
+	}
+	if body.Permission != models.SharePermissionView && body.Permission != models.SharePermissionEdit {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "permission must be view or edit",
+		})
+	}
+
+	task, _, err := findTask(db, c.Param("id"), userID, accessOwner)
+	if err != nil {
+		return taskAccessError(c, err)
+	}
+
+	err = db.Where("LOWER(email) = ?", models.NormalizeEmail(body.Email)).First(&grantee).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": "no user with this email address",
+		})
+	}
+	if err != nil {
+		return utils.InternalServerError(c, "could not retrieve user", err)
+	}
+	if grantee.ID == userID {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "you cannot share a task with yourself",
+		})
+	}
+
+	share := models.TaskShare{TaskID: task.ID, UserID: grantee.ID, Permission: body.Permission}
+	err = db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "task_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"permission"}),
+	}).Create(&share).Error
+	if err != nil {
+		return utils.InternalServerError(c, "could not share task", err)
+	}
+	share.User = grantee
+
+	return c.JSON(http.StatusOK, dto.Response{
+		Message: "task shared",
+		Data:    taskShareResponse(share),
+	})
+}
+
+// GetTaskShares godoc
+// @Summary List the shares of a task
+// @Description List the users a task owned by the authenticated user is shared with
+// @Tags tasks
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Task ID"
+// @Success 200 {object} dto.Response{data=[]dto.TaskShareResponse}
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /tasks/{id}/shares [get]
+func GetTaskShares(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	var shares []models.TaskShare
+
+	task, _, err := findTask(db, c.Param("id"), utils.GetUserID(c), accessOwner)
+	if err != nil {
+		return taskAccessError(c, err)
+	}
+
+	if err := db.Preload("User").Where("task_id = ?", task.ID).Order("id").Find(&shares).Error; err != nil {
+		return utils.InternalServerError(c, "could not retrieve shares", err)
+	}
+
+	response := make([]dto.TaskShareResponse, 0, len(shares))
+	for _, share := range shares {
+		response = append(response, taskShareResponse(share))
+	}
+
+	return c.JSON(http.StatusOK, dto.Response{Message: "success", Data: response})
+}
+
+// DeleteTaskShare godoc
+// @Summary Stop sharing a task
+// @Description Remove the access of a user to a task owned by the authenticated user
+// @Tags tasks
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Task ID"
+// @Param share_id path string true "Share ID"
+// @Success 200 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /tasks/{id}/shares/{share_id} [delete]
+func DeleteTaskShare(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+
+	task, _, err := findTask(db, c.Param("id"), utils.GetUserID(c), accessOwner)
+	if err != nil {
+		return taskAccessError(c, err)
+	}
+
+	result := db.Where("id = ? AND task_id = ?", c.Param("share_id"), task.ID).Delete(&models.TaskShare{})
+	if result.Error != nil {
+		return utils.InternalServerError(c, "could not remove share", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": "share not found",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "share removed",
+	})
+}
+
+// GetSharedTasks godoc
+// @Summary List tasks shared with me
+// @Description Get all tasks other users have shared with the authenticated user
+// @Tags tasks
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} dto.Response{data=[]dto.TaskResponse}
+// @Failure 500 {object} map[string]string
+// @Router /tasks/shared [get]
+func GetSharedTasks(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	var tasks []models.Task
+
+	sharedIDs := db.Model(&models.TaskShare{}).Select("task_id").Where("user_id = ?", utils.GetUserID(c))
+	if err := db.Where("id IN (?)", sharedIDs).Order("id").Preload("Images", orderImages).Find(&tasks).Error; err != nil {
+		return utils.InternalServerError(c, "could not retrieve tasks", err)
+	}
+
+	taskResponses := []dto.TaskResponse{}
+	for _, task := range tasks {
+		taskResponses = append(taskResponses, taskResponse(task))
+	}
+
+	return c.JSON(http.StatusOK, dto.Response{Message: "success", Data: taskResponses})
+}
+
+func taskShareResponse(share models.TaskShare) dto.TaskShareResponse {
+	return dto.TaskShareResponse{
+		ID:         share.ID,
+		UserID:     share.UserID,
+		Username:   share.User.Username,
+		Email:      share.User.Email,
+		Permission: share.Permission,
+		CreatedAt:  share.CreatedAt,
+	}
+}
@@ -0,0 +1,489 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"todo-app/config"
+	"todo-app/models"
+	"todo-app/models/dto"
+	"todo-app/ratelimit"
+	"todo-app/utils"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	totpPeriod        = 30
+	recoveryCodeCount = 10
+)
+
+var totpOpts = totp.ValidateOpts{
+	Period:    totpPeriod,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// EnrollTOTP godoc
+// @Summary Start two-factor enrollment
+// @Description Generate a new TOTP secret for the authenticated user. Two-factor authentication is enabled once a code from the authenticator app is confirmed with /auth/me/2fa/verify.
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} dto.Response{data=dto.TOTPEnrollmentResponse}
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/me/2fa/enroll [post]
+func EnrollTOTP(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	userID := utils.GetUserID(c)
+	var user models.User
+
+	if err := db.First(&user, userID).Error; err != nil {
+		return utils.InternalServerError(c, "could not retrieve user", err)
+	}
+	if user.TOTPEnabledAt != nil {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"message": "two-factor authentication is already enabled",
+		})
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      config.Cfg.Auth.TOTPIssuer,
+		AccountName: user.Email,
+		Period:      totpPeriod,
+		Digits:      totpOpts.Digits,
+		Algorithm:   totpOpts.Algorithm,
+	})
+	if err != nil {
+		return utils.InternalServerError(c, "could not generate secret", err)
+	}
+
+	img, err := key.Image(256, 256)
+	if err != nil {
+		return utils.InternalServerError(c, "could not generate QR code", err)
+	}
+	var qr bytes.Buffer
+	if err := png.Encode(&qr, img); err != nil {
+		return utils.InternalServerError(c, "could not generate QR code", err)
+	}
+
+	encrypted, err := utils.EncryptSecret(key.Secret())
+	if err != nil {
+		return utils.InternalServerError(c, "could not store secret", err)
+	}
+	if err := db.Model(&user).Updates(map[string]interface{}{
+		"totp_secret":    encrypted,
+		"totp_last_step": 0,
+	}).Error; err != nil {
+		return utils.InternalServerError(c, "could not store secret", err)
+	}
+
+	return c.JSON(http.StatusOK, dto.Response{
+		Message: "scan the QR code with your authenticator app and confirm with a code",
+		Data: dto.TOTPEnrollmentResponse{
+			Secret:     key.Secret(),
+			OTPAuthURI: key.URL(),
+			QRCodePNG:  base64.StdEncoding.EncodeToString(qr.Bytes()),
+		},
+	})
+}
+
+// VerifyTOTP godoc
+// @Summary Confirm two-factor enrollment
+// @Description Enable two-factor authentication with a code from the authenticator app. Returns one-time recovery codes, which are only shown once.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param code body dto.TOTPRequest true "TOTP code"
+// @Success 200 {object} dto.Response
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/me/2fa/verify [post]
+func VerifyTOTP(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	userID := utils.GetUserID(c)
+	var body dto.TOTPRequest
+	var user models.User
+
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "invalid input",
+		})
+	}
+
+	if err := db.First(&user, userID).Error; err != nil {
+		return utils.InternalServerError(c, "could not retrieve user", err)
+	}
+	if user.TOTPEnabledAt != nil || user.TOTPSecret == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "start the enrollment first",
+		})
+	}
+
+	step, ok, err := checkTOTP(user, body.Code)
+	if err != nil {
+		return utils.InternalServerError(c, "could not verify code", err)
+	}
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"message": "invalid code",
+		})
+	}
+
+	var codes []string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"totp_enabled_at": time.Now(),
+			"totp_last_step":  step,
+		}).Error; err != nil {
+			return err
+		}
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return utils.InternalServerError(c, "could not enable two-factor authentication", err)
+	}
+
+	return c.JSON(http.StatusOK, dto.Response{
+		Message: "two-factor authentication enabled, store the recovery codes in a safe place",
+		Data: map[string][]string{
+			"recovery_codes": codes,
+		},
+	})
+}
+
+// DisableTOTP godoc
+// @Summary Disable two-factor authentication
+// @Description Disable two-factor authentication. Requires the password and a TOTP or recovery code.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param credentials body dto.DisableTOTPRequest true "Password and code"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/me/2fa [delete]
+func DisableTOTP(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	userID := utils.GetUserID(c)
+	var body dto.DisableTOTPRequest
+	var user models.User
+
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "invalid input",
+		})
+	}
+
+	if err := db.First(&user, userID).Error; err != nil {
+		return utils.InternalServerError(c, "could not retrieve user", err)
+	}
+	if user.TOTPEnabledAt == nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "two-factor authentication is not enabled",
+		})
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.Password)); err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"message": "password is incorrect",
+		})
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		ok, err := useSecondFactor(tx, user, body.Code)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errInvalidSecondFactor
+		}
+
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"totp_secret":     "",
+			"totp_enabled_at": nil,
+			"totp_last_step":  0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+	if errors.Is(err, errInvalidSecondFactor) {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"message": "invalid code",
+		})
+	}
+	if err != nil {
+		return utils.InternalServerError(c, "could not disable two-factor authentication", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "two-factor authentication disabled",
+	})
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary Regenerate recovery codes
+// @Description Replace all recovery codes. Requires a TOTP code.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param code body dto.TOTPRequest true "TOTP code"
+// @Success 200 {object} dto.Response
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/me/2fa/recovery-codes [post]
+func RegenerateRecoveryCodes(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	userID := utils.GetUserID(c)
+	var body dto.TOTPRequest
+	var user models.User
+
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "invalid input",
+		})
+	}
+
+	if err := db.First(&user, userID).Error; err != nil {
+		return utils.InternalServerError(c, "could not retrieve user", err)
+	}
+	if user.TOTPEnabledAt == nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "two-factor authentication is not enabled",
+		})
+	}
+
+	step, ok, err := checkTOTP(user, body.Code)
+	if err != nil {
+		return utils.InternalServerError(c, "could not verify code", err)
+	}
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"message": "invalid code",
+		})
+	}
+
+	var codes []string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("totp_last_step", step).Error; err != nil {
+			return err
+		}
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return utils.InternalServerError(c, "could not generate recovery codes", err)
+	}
+
+	return c.JSON(http.StatusOK, dto.Response{
+		Message: "recovery codes regenerated, the previous codes no longer work",
+		Data: map[string][]string{
+			"recovery_codes": codes,
+		},
+	})
+}
+
+// LoginMFA godoc
+// @Summary Complete a two-factor login
+// @Description Exchange the challenge token returned by /auth/login and a TOTP or recovery code for a JWT token
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param login body dto.MFALoginRequest true "Challenge and code"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/login/2fa [post]
+func LoginMFA(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	ctx := c.Request().Context()
+	var body dto.MFALoginRequest
+	var challenge models.UserToken
+	var user models.User
+
+	if err := c.Bind(&body); err != nil || body.ChallengeToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "invalid input",
+		})
+	}
+
+	err := db.Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?",
+		utils.HashToken(body.ChallengeToken), models.TokenPurposeMFAChallenge, time.Now()).First(&challenge).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"message": "invalid or expired challenge, log in again",
+		})
+	}
+	if err != nil {
+		return utils.InternalServerError(c, "could not retrieve challenge", err)
+	}
+
+	mfaKey := "mfa:user:" + strconv.FormatUint(uint64(challenge.UserID), 10)
+	lockout, _ := loginLockouts()
+	locked, err := lockout.Check(ctx, mfaKey)
+	if err != nil {
+		return utils.InternalServerError(c, "could not check login attempts", err)
+	}
+	if locked > 0 {
+		return utils.TooManyRequests(c, locked, "too many failed attempts, try again later")
+	}
+
+	if err := db.First(&user, challenge.UserID).Error; err != nil {
+		return utils.InternalServerError(c, "could not retrieve user", err)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		ok, err := useSecondFactor(tx, user, body.Code)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errInvalidSecondFactor
+		}
+		_, err = consumeUserToken(tx, body.ChallengeToken, models.TokenPurposeMFAChallenge)
+		return err
+	})
+	if errors.Is(err, errInvalidSecondFactor) {
+		return failSecondFactor(c, lockout, mfaKey)
+	}
+	if errors.Is(err, errInvalidToken) {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"message": "invalid or expired challenge, log in again",
+		})
+	}
+	if err != nil {
+		return utils.InternalServerError(c, "could not verify code", err)
+	}
+
+	if err := lockout.Reset(ctx, mfaKey); err != nil {
+		return utils.InternalServerError(c, "could not reset login attempts", err)
+	}
+
+	token, err := grantAccess(db, user)
+	if errors.Is(err, errAccountDisabled) {
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
+		return utils.InternalServerError(c, "failed to generate token", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"token": token,
+	})
+}
+
+var errInvalidSecondFactor = errors.New("invalid second factor")
+
+func failSecondFactor(c echo.Context, lockout ratelimit.Lockout, key string) error {
+	locked, err := lockout.Fail(c.Request().Context(), key)
+	if err != nil {
+		return utils.InternalServerError(c, "could not record login attempt", err)
+	}
+	if locked > 0 {
+		return utils.TooManyRequests(c, locked, "too many failed attempts, try again later")
+	}
+
+	return c.JSON(http.StatusUnauthorized, map[string]string{
+		"message": "invalid code",
+	})
+}
+
+// checkTOTP validates code against the user's secret, allowing one step of
+// clock drift. Codes from steps at or before the last accepted one are
+// rejected so that an observed code cannot be replayed.
+func checkTOTP(user models.User, code string) (int64, bool, error) {
+	secret, err := utils.DecryptSecret(user.TOTPSecret)
+	if err != nil {
+		return 0, false, err
+	}
+
+	code = strings.TrimSpace(code)
+	current := time.Now().Unix() / totpPeriod
+	for _, step := range []int64{current - 1, current, current + 1} {
+		if step <= user.TOTPLastStep {
+			continue
+		}
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), totpOpts)
+		if err != nil {
+			return 0, false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// useSecondFactor accepts either a TOTP code or an unused recovery code and
+// records its use.
+func useSecondFactor(tx *gorm.DB, user models.User, code string) (bool, error) {
+	step, ok, err := checkTOTP(user, code)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		result := tx.Model(&models.User{}).Where("id = ? AND totp_last_step < ?", user.ID, step).Update("totp_last_step", step)
+		return result.RowsAffected == 1, result.Error
+	}
+
+	result := tx.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashRecoveryCode(code)).
+		Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	records := make([]models.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+		code = code[:5] + "-" + code[5:]
+
+		codes = append(codes, code)
+		records = append(records, models.RecoveryCode{UserID: userID, CodeHash: hashRecoveryCode(code)})
+	}
+
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return utils.HashToken("recovery:" + normalized)
+}
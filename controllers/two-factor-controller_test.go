@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+	"todo-app/models"
+	"todo-app/utils"
+
+	"github.com/pquerna/otp/totp"
+)
+
+func totpUser(t *testing.T, secret string, lastStep int64) models.User {
+	t.Helper()
+	encrypted, err := utils.EncryptSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return models.User{TOTPSecret: encrypted, TOTPLastStep: lastStep}
+}
+
+func totpCode(t *testing.T, secret string, step int64) string {
+	t.Helper()
+	code, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), totpOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// withinStep runs check with the current time step, again if the step
+// changed meanwhile, so that results do not depend on crossing a boundary.
+func withinStep(t *testing.T, check func(current int64) error) {
+	t.Helper()
+	for {
+		current := time.Now().Unix() / totpPeriod
+		err := check(current)
+		if time.Now().Unix()/totpPeriod != current {
+			continue
+		}
+		if err != nil {
+			t.Error(err)
+		}
+		return
+	}
+}
+
+func TestCheckTOTP(t *testing.T) {
+	const secret = "JBSWY3DPEHPK3PXP"
+
+	// Steps are relative to the current one.
+	tests := []struct {
+		name     string
+		lastStep int64
+		step     int64
+		ok       bool
+	}{
+		{"current step", -100, 0, true},
+		{"previous step within drift", -100, -1, true},
+		{"next step within drift", -100, 1, true},
+		{"step beyond drift", -100, -3, false},
+		{"replayed step", 0, 0, false},
+		{"step before last accepted", 0, -1, false},
+		{"step after last accepted", -1, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withinStep(t, func(current int64) error {
+				user := totpUser(t, secret, current+tt.lastStep)
+				step, ok, err := checkTOTP(user, totpCode(t, secret, current+tt.step))
+				switch {
+				case err != nil:
+					return err
+				case ok != tt.ok:
+					return fmt.Errorf("ok = %v, want %v", ok, tt.ok)
+				case ok && step != current+tt.step:
+					return fmt.Errorf("step = %d, want %d", step, current+tt.step)
+				}
+				return nil
+			})
+		})
+	}
+}
+
+func TestCheckTOTPRejectsWrongCode(t *testing.T) {
+	const secret = "JBSWY3DPEHPK3PXP"
+
+	withinStep(t, func(current int64) error {
+		code := totpCode(t, "GEZDGNBVGY3TQOJQ", current)
+		if _, ok, _ := checkTOTP(totpUser(t, secret, 0), code); ok {
+			return errors.New("code of another secret accepted")
+		}
+		if _, ok, _ := checkTOTP(totpUser(t, secret, 0), ""); ok {
+			return errors.New("empty code accepted")
+		}
+		padded := " " + totpCode(t, secret, current) + "\n"
+		if _, ok, _ := checkTOTP(totpUser(t, secret, 0), padded); !ok {
+			return errors.New("code with surrounding spaces rejected")
+		}
+		return nil
+	})
+}
+
+func TestCheckTOTPUndecryptableSecret(t *testing.T) {
+	if _, _, err := checkTOTP(models.User{TOTPSecret: "not a secret"}, "123456"); err == nil {
+		t.Error("no error for a secret that cannot be decrypted")
+	}
+}
+
+func TestHashRecoveryCodeNormalizes(t *testing.T) {
+	want := hashRecoveryCode("abcde-fghij")
+	for _, code := range []string{"ABCDE-FGHIJ", "abcdefghij", "abcde fghij", "AbCdE-fGhIj"} {
+		if got := hashRecoveryCode(code); got != want {
+			t.Errorf("hash of %q differs from the canonical form", code)
+		}
+	}
+	if hashRecoveryCode("abcde-fghik") == want {
+		t.Error("different codes share a hash")
+	}
+}
@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"todo-app/config"
+	"todo-app/models"
+	"todo-app/models/dto"
+	"todo-app/utils"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// imageSizeSQL is the size of an attachment. Attachments uploaded before
+// sizes were recorded are measured.
+const imageSizeSQL = "COALESCE(NULLIF(images.size, 0), OCTET_LENGTH(images.data), 0)"
+
+// GetUsage godoc
+// @Summary Get storage usage
+// @Description Get the size and number of the attachments on the tasks of the authenticated user, in total and by task, and their quota. Attachments count against the quota of the task owner, whoever uploaded them.
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} dto.Response{data=dto.UsageResponse}
+// @Failure 500 {object} map[string]string
+// @Router /auth/me/usage [get]
+func GetUsage(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	var user models.User
+	tasks := []dto.TaskUsageResponse{}
+
+	if err := db.First(&user, utils.GetUserID(c)).Error; err != nil {
+		return utils.InternalServerError(c, "could not retrieve user", err)
+	}
+
+	err := db.Model(&models.Task{}).
+		Select("tasks.id AS task_id, tasks.title, SUM("+imageSizeSQL+") AS bytes, COUNT(*) AS files").
+		Joins("JOIN images ON images.task_id = tasks.id").
+		Where("tasks.user_id = ?", user.ID).
+		Group("tasks.id").
+		Order("bytes DESC, tasks.id").
+		Scan(&tasks).Error
+	if err != nil {
+		return utils.InternalServerError(c, "could not retrieve usage", err)
+	}
+
+	response := dto.UsageResponse{Tasks: tasks}
+	response.QuotaBytes, response.QuotaFiles = userQuota(user)
+	for _, task := range tasks {
+		response.Bytes += task.Bytes
+		response.Files += task.Files
+	}
+
+	return c.JSON(http.StatusOK, dto.Response{Message: "success", Data: response})
+}
+
+// quotaError is returned when an attachment does not fit in the quota of the
+// task owner.
+type quotaError struct {
+	remainingBytes, remainingFiles int64
+	limitBytes, limitFiles         int64
+}
+
+func (e *quotaError) Error() string {
+	remaining := []string{}
+	if e.limitBytes > 0 {
+		remaining = append(remaining, fmt.Sprintf("%d of %d bytes", e.remainingBytes, e.limitBytes))
+	}
+	if e.limitFiles > 0 {
+		remaining = append(remaining, fmt.Sprintf("%d of %d files", e.remainingFiles, e.limitFiles))
+	}
+	return "storage quota exceeded, " + strings.Join(remaining, " and ") + " remaining"
+}
+
+// userQuota returns the storage limits of user. Zero means unlimited.
+func userQuota(user models.User) (bytes, files int64) {
+	bytes, files = config.Cfg.Upload.QuotaBytes, config.Cfg.Upload.QuotaFiles
+	if user.QuotaBytes != nil {
+		bytes = *user.QuotaBytes
+	}
+	if user.QuotaFiles != nil {
+		files = *user.QuotaFiles
+	}
+	return bytes, files
+}
+
+// reserveStorage checks that an attachment of size fits in the quota of the
+// task owner. It locks the owner, so call it in the transaction that stores
+// the attachment to count concurrent uploads one after the other.
+func reserveStorage(tx *gorm.DB, ownerID uint, size int64) error {
+	var owner models.User
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&owner, ownerID).Error; err != nil {
+		return err
+	}
+	limitBytes, limitFiles := userQuota(owner)
+	if limitBytes == 0 && limitFiles == 0 {
+		return nil
+	}
+
+	var usage struct{ Bytes, Files int64 }
+	err := tx.Model(&models.Image{}).
+		Select("COALESCE(SUM("+imageSizeSQL+"), 0) AS bytes, COUNT(*) AS files").
+		Joins("JOIN tasks ON tasks.id = images.task_id").
+		Where("tasks.user_id = ?", ownerID).
+		Scan(&usage).Error
+	if err != nil {
+		return err
+	}
+
+	return checkQuota(limitBytes, limitFiles, usage.Bytes, usage.Files, size)
+}
+
+// checkQuota returns a quotaError if another attachment of size does not fit
+// next to the bytes and files in use. Zero limits mean unlimited.
+func checkQuota(limitBytes, limitFiles, usedBytes, usedFiles, size int64) error {
+	if (limitBytes > 0 && usedBytes+size > limitBytes) || (limitFiles > 0 && usedFiles >= limitFiles) {
+		return &quotaError{
+			remainingBytes: max(0, limitBytes-usedBytes),
+			remainingFiles: max(0, limitFiles-usedFiles),
+			limitBytes:     limitBytes,
+			limitFiles:     limitFiles,
+		}
+	}
+	return nil
+}
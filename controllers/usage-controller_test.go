@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"todo-app/config"
+	"todo-app/models"
+	"todo-app/upload"
+
+	"gorm.io/gorm"
+)
+
+func TestCheckQuota(t *testing.T) {
+	tests := []struct {
+		name                           string
+		limitBytes, limitFiles         int64
+		usedBytes, usedFiles, size     int64
+		exceeded                       bool
+		remainingBytes, remainingFiles int64
+	}{
+		{name: "unlimited", usedBytes: 1 << 40, usedFiles: 1 << 20, size: 1 << 30},
+		{name: "fits", limitBytes: 100, limitFiles: 10, usedBytes: 50, usedFiles: 5, size: 50},
+		{name: "too large", limitBytes: 100, limitFiles: 10, usedBytes: 50, usedFiles: 5, size: 51,
+			exceeded: true, remainingBytes: 50, remainingFiles: 5},
+		{name: "too many files", limitBytes: 100, limitFiles: 10, usedBytes: 0, usedFiles: 10, size: 1,
+			exceeded: true, remainingBytes: 100},
+		{name: "only files limited", limitFiles: 10, usedBytes: 1 << 40, usedFiles: 9, size: 1 << 30},
+		{name: "only bytes limited", limitBytes: 100, usedFiles: 1 << 20, size: 100},
+		{name: "over after a lowered limit", limitBytes: 100, usedBytes: 150, size: 1,
+			exceeded: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkQuota(tt.limitBytes, tt.limitFiles, tt.usedBytes, tt.usedFiles, tt.size)
+			var quotaErr *quotaError
+			if errors.As(err, &quotaErr) != tt.exceeded {
+				t.Fatalf("error = %v, want exceeded %v", err, tt.exceeded)
+			}
+			if !tt.exceeded {
+				return
+			}
+			if quotaErr.remainingBytes != tt.remainingBytes || quotaErr.remainingFiles != tt.remainingFiles {
+				t.Errorf("remaining = %d bytes and %d files, want %d and %d",
+					quotaErr.remainingBytes, quotaErr.remainingFiles, tt.remainingBytes, tt.remainingFiles)
+			}
+		})
+	}
+}
+
+func TestQuotaErrorAnswer(t *testing.T) {
+	err := checkQuota(100, 0, 90, 0, 20)
+	status, message, ok := uploadFailure(upload.File{}, err)
+	if !ok || status != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, ok = %v, want %d", status, ok, http.StatusRequestEntityTooLarge)
+	}
+	if want := "storage quota exceeded, 10 of 100 bytes remaining"; message != want {
+		t.Errorf("message = %q, want %q", message, want)
+	}
+}
+
+func TestUserQuota(t *testing.T) {
+	bytes, files := userQuota(models.User{})
+	if bytes != config.Cfg.Upload.QuotaBytes || files != config.Cfg.Upload.QuotaFiles {
+		t.Errorf("default quota = %d bytes and %d files, want the configured %d and %d",
+			bytes, files, config.Cfg.Upload.QuotaBytes, config.Cfg.Upload.QuotaFiles)
+	}
+
+	unlimited, more := int64(0), int64(50_000)
+	bytes, files = userQuota(models.User{QuotaBytes: &unlimited, QuotaFiles: &more})
+	if bytes != 0 || files != more {
+		t.Errorf("overridden quota = %d bytes and %d files, want 0 and %d", bytes, files, more)
+	}
+}
+
+func TestReserveStorageLocksOwner(t *testing.T) {
+	db, recorder := dryRunDB(t)
+
+	// Scanning the usage is not supported in dry-run mode, so only the
+	// owner query is built.
+	if err := reserveStorage(db, 7, 100); err != nil && !errors.Is(err, gorm.ErrDryRunModeUnsupported) {
+		t.Fatal(err)
+	}
+	if len(recorder.statements) == 0 {
+		t.Fatal("no statement built")
+	}
+	// Concurrent uploads are counted one after the other only if the owner
+	// row is locked before the usage is read.
+	if owner := recorder.statements[0]; !strings.Contains(owner, `"users"."id" = 7`) || !strings.HasSuffix(owner, "FOR UPDATE") {
+		t.Errorf("owner query does not lock the owner: %s", owner)
+	}
+}
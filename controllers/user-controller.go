@@ -1,32 +1,38 @@
 package controllers
 
 import (
+	"errors"
+	"log/slog"
 	"net/http"
-	"os"
-	"time"
+	"sync"
 	"todo-app/config"
 	"todo-app/models"
 	"todo-app/models/dto"
+	"todo-app/ratelimit"
 	"todo-app/utils"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 // Login godoc
 // @Summary Login a user
-// @Description Authenticate a user and return a JWT token
+// @Description Authenticate a user and return a JWT token. When two-factor authentication is enabled, a challenge token is returned instead, to be exchanged together with a code at /auth/login/2fa. Repeated failures lock the email and the client IP out for a growing period. Logging in restores an account scheduled for deletion.
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param login body dto.LoginRequest true "Login"
 // @Success 200 {object} map[string]string
 // @Failure 400 {object} map[string]string
-// @Failure 404 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string "Email address not verified or account disabled"
+// @Failure 429 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /auth/login [post]
 func Login(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	ctx := c.Request().Context()
 	var body dto.LoginRequest
 	var user models.User
 
@@ -36,45 +42,150 @@ func Login(c echo.Context) error {
 		})
 	}
 
-	if err := config.DB.Where("email = ?", body.Email).First(&user).Error; err != nil {
-		return c.JSON(http.StatusNotFound, map[string]string{
-			"message": "Invalid credentials. Please check your email",
-		})
+	emailKey := "login:email:" + models.NormalizeEmail(body.Email)
+	ipKey := "login:ip:" + c.RealIP()
+	accountLockout, ipLockout := loginLockouts()
+
+	locked, err := accountLockout.Check(ctx, emailKey, ipKey)
+	if err != nil {
+		return utils.InternalServerError(c, "could not check login attempts", err)
+	}
+	if locked > 0 {
+		return utils.TooManyRequests(c, locked, "too many failed login attempts, try again later")
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.Password)); err != nil {
+	// Both unknown emails and wrong passwords get the same answer, and a
+	// hash comparison runs in both cases so that timing does not reveal
+	// which accounts exist.
+	passwordHash := dummyPasswordHash()
+	err = db.Where("LOWER(email) = ?", models.NormalizeEmail(body.Email)).First(&user).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return utils.InternalServerError(c, "could not retrieve user", err)
+	}
+	if err == nil {
+		passwordHash = []byte(user.Password)
+	}
+
+	if bcrypt.CompareHashAndPassword(passwordHash, []byte(body.Password)) != nil || user.ID == 0 {
+		accountLocked, err := accountLockout.Fail(ctx, emailKey)
+		if err != nil {
+			return utils.InternalServerError(c, "could not record login attempt", err)
+		}
+		ipLocked, err := ipLockout.Fail(ctx, ipKey)
+		if err != nil {
+			return utils.InternalServerError(c, "could not record login attempt", err)
+		}
+		if accountLocked > 0 || ipLocked > 0 {
+			return utils.TooManyRequests(c, max(accountLocked, ipLocked), "too many failed login attempts, try again later")
+		}
+
 		return c.JSON(http.StatusUnauthorized, map[string]string{
-			"message": "Invalid credentials. Please check your password.",
+			"message": "invalid email or password",
 		})
 	}
 
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"message": "failed to get JWT secret",
+	if err := accountLockout.Reset(ctx, emailKey); err != nil {
+		return utils.InternalServerError(c, "could not reset login attempts", err)
+	}
+
+	if config.Cfg.Auth.RequireVerifiedEmail && user.EmailVerifiedAt == nil {
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"message": "email address is not verified",
 		})
 	}
-	claims := jwt.MapClaims{
-		"user_id": user.ID,
-		"exp":     time.Now().Add(time.Hour * 24 * 7).Unix(),
-		"iat":     time.Now().Unix(),
+
+	response, err := loginResponse(db, user)
+	if errors.Is(err, errAccountDisabled) {
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"message": err.Error(),
+		})
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(jwtSecret))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, map[string]string{
-			"message": "failed to generate token",
-		})
+		return utils.InternalServerError(c, "could not complete login", err)
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+var errAccountDisabled = errors.New("account is disabled")
+
+// loginResponse answers a successful first factor: a two-factor challenge
+// when TOTP is enabled, the access token otherwise.
+func loginResponse(db *gorm.DB, user models.User) (map[string]interface{}, error) {
+	if user.DisabledAt != nil {
+		return nil, errAccountDisabled
+	}
+
+	if user.TOTPEnabledAt != nil {
+		challenge, err := issueUserToken(db, user.ID, models.TokenPurposeMFAChallenge, config.Cfg.Auth.MFAChallengeTTL)
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]interface{}{
+			"mfa_required":    true,
+			"challenge_token": challenge,
+			"expires_in":      int(config.Cfg.Auth.MFAChallengeTTL.Seconds()),
+		}, nil
+	}
+
+	token, err := grantAccess(db, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"token": token}, nil
+}
+
+// grantAccess issues the access token once every factor has been checked.
+func grantAccess(db *gorm.DB, user models.User) (string, error) {
+	if user.DisabledAt != nil {
+		return "", errAccountDisabled
 	}
 
-	return c.JSON(http.StatusOK, map[string]string{
-		"token": tokenString,
+	// Logging in during the grace period restores an account scheduled for
+	// deletion.
+	if user.DeletionScheduledAt != nil {
+		if err := db.Model(&user).Update("deletion_scheduled_at", nil).Error; err != nil {
+			return "", err
+		}
+	}
+
+	return issueAccessToken(db, user)
+}
+
+func loginLockouts() (account ratelimit.Lockout, ip ratelimit.Lockout) {
+	cfg := config.Cfg.RateLimit.Login
+	account = ratelimit.Lockout{
+		Store:         ratelimit.DefaultStore,
+		MaxFailures:   cfg.MaxFailures,
+		FailureWindow: cfg.FailureWindow,
+		BaseLockout:   cfg.Lockout,
+		MaxLockout:    cfg.MaxLockout,
+	}
+	ip = account
+	ip.MaxFailures = cfg.IPMaxFailures
+
+	return account, ip
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// dummyPasswordHash returns a bcrypt hash to compare against when the email
+// is unknown.
+func dummyPasswordHash() []byte {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
 	})
+	return dummyHash
 }
 
 // Register godoc
 // @Summary Register a new user
-// @Description Create a new user account
+// @Description Create a new user account and send a verification email
 // @Tags auth
 // @Accept json
 // @Produce json
@@ -84,12 +195,14 @@ func Login(c echo.Context) error {
 // @Failure 500 {object} map[string]string
 // @Router /auth/register [post]
 func Register(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
 	var user models.User
 	if err := c.Bind(&user); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"message": "invalid input",
 		})
 	}
+	user.Email = models.NormalizeEmail(user.Email)
 
 	if user.Username == "" || user.Email == "" || user.Password == "" {
         return c.JSON(http.StatusBadRequest, map[string]string{
@@ -99,20 +212,21 @@ func Register(c echo.Context) error {
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"message": "failed to hash password",
-		})
+		return utils.InternalServerError(c, "failed to hash password", err)
 	}
 	user.Password = string(hashedPassword)
 
-	if err := config.DB.Create(&user).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"message": "failed to create user",
-		})
+	user.EmailVerifiedAt = nil
+	if err := db.Create(&user).Error; err != nil {
+		return utils.InternalServerError(c, "failed to create user", err)
+	}
+
+	if err := sendVerificationEmail(c.Request().Context(), db, user); err != nil {
+		slog.ErrorContext(c.Request().Context(), "could not send verification email", "error", err)
 	}
 
 	return c.JSON(http.StatusCreated, map[string]string{
-		"message": "user registered successfully",
+		"message": "user registered successfully, check your email to verify your address",
 	})
 }
 
@@ -126,23 +240,16 @@ func Register(c echo.Context) error {
 // @Failure 500 {object} map[string]string
 // @Router /auth/me [get]
 func GetMe(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
 	userID := utils.GetUserID(c)
 
 	var user models.User
-	if err := config.DB.First(&user, uint(userID)).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"message": "could not retrieve user",
-		})
+	if err := db.First(&user, uint(userID)).Error; err != nil {
+		return utils.InternalServerError(c, "could not retrieve user", err)
 	}
 
 	return c.JSON(http.StatusOK, dto.Response{
 		Message: "success",
-		Data: dto.UserResponse{
-			ID:        user.ID,
-			Username:  user.Username,
-			Email:     user.Email,
-			CreatedAt: user.CreatedAt,
-			UpdatedAt: user.UpdatedAt,
-		},
+		Data:    userResponse(user),
 	})
 }
@@ -0,0 +1,368 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"todo-app/config"
+	"todo-app/mailer"
+	"todo-app/models"
+	"todo-app/models/dto"
+	"todo-app/ratelimit"
+	"todo-app/utils"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var errInvalidToken = errors.New("invalid or expired token")
+
+// VerifyEmail godoc
+// @Summary Verify an email address
+// @Description Confirm the email address of an account with the token sent by email
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param token body dto.TokenRequest true "Verification token"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/verify-email [post]
+func VerifyEmail(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	var body dto.TokenRequest
+
+	if err := c.Bind(&body); err != nil || body.Token == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "invalid input",
+		})
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		token, err := consumeUserToken(tx, body.Token, models.TokenPurposeVerifyEmail)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).Where("id = ? AND email_verified_at IS NULL", token.UserID).
+			Update("email_verified_at", time.Now()).Error
+	})
+	if errors.Is(err, errInvalidToken) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "invalid or expired token",
+		})
+	}
+	if err != nil {
+		return utils.InternalServerError(c, "could not verify email", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "email verified successfully",
+	})
+}
+
+// ResendVerification godoc
+// @Summary Resend the verification email
+// @Description Send a new verification link. The email is sent in the background, so the response is the same and as fast whether or not the account exists.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param email body dto.EmailRequest true "Email"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Router /auth/resend-verification [post]
+func ResendVerification(c echo.Context) error {
+	var body dto.EmailRequest
+
+	if err := c.Bind(&body); err != nil || body.Email == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "invalid input",
+		})
+	}
+
+	inBackground(c, func(ctx context.Context, db *gorm.DB) error {
+		var user models.User
+		err := db.Where("LOWER(email) = ? AND email_verified_at IS NULL", models.NormalizeEmail(body.Email)).First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return sendVerificationEmail(ctx, db, user)
+	})
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "if the account exists and is not verified yet, a verification email has been sent",
+	})
+}
+
+// ForgotPassword godoc
+// @Summary Request a password reset
+// @Description Send a password reset link. The email is sent in the background, so the response is the same and as fast whether or not the account exists.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param email body dto.EmailRequest true "Email"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Router /auth/forgot-password [post]
+func ForgotPassword(c echo.Context) error {
+	var body dto.EmailRequest
+
+	if err := c.Bind(&body); err != nil || body.Email == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "invalid input",
+		})
+	}
+
+	inBackground(c, func(ctx context.Context, db *gorm.DB) error {
+		var user models.User
+		err := db.Where("LOWER(email) = ?", models.NormalizeEmail(body.Email)).First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return sendPasswordResetEmail(ctx, db, user)
+	})
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "if the account exists, a password reset email has been sent",
+	})
+}
+
+// ResetPassword godoc
+// @Summary Reset a password
+// @Description Set a new password with the token sent by email. All sessions of the account are logged out.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param reset body dto.ResetPasswordRequest true "Reset password"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/reset-password [post]
+func ResetPassword(c echo.Context) error {
+	db := config.DB.WithContext(c.Request().Context())
+	var body dto.ResetPasswordRequest
+
+	if err := c.Bind(&body); err != nil || body.Token == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "invalid input",
+		})
+	}
+	if body.Password == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "password is required",
+		})
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return utils.InternalServerError(c, "failed to hash password", err)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		token, err := consumeUserToken(tx, body.Token, models.TokenPurposeResetPassword)
+		if err != nil {
+			return err
+		}
+
+		// Following the link proves control of the inbox, so the address
+		// counts as verified from now on.
+		now := time.Now()
+		if err := tx.Model(&models.User{}).Where("id = ?", token.UserID).Updates(map[string]interface{}{
+			"password":          string(hashedPassword),
+			"email_verified_at": gorm.Expr("COALESCE(email_verified_at, ?)", now),
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := revokeUserTokens(tx, token.UserID, models.TokenPurposeResetPassword); err != nil {
+			return err
+		}
+		return revokeSessions(tx, token.UserID, "")
+	})
+	if errors.Is(err, errInvalidToken) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "invalid or expired token",
+		})
+	}
+	if err != nil {
+		return utils.InternalServerError(c, "could not reset password", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "password reset successfully",
+	})
+}
+
+// maxBackgroundEmails bounds the emails being sent at once by inBackground.
+const maxBackgroundEmails = 100
+
+var (
+	backgroundEmails     sync.WaitGroup
+	backgroundEmailSlots = make(chan struct{}, maxBackgroundEmails)
+)
+
+// inBackground runs job after the response has been sent, so that how long
+// looking up the account and talking to the mail server takes does not reveal
+// whether an account exists. Jobs get mail.timeout to finish, and are dropped
+// while maxBackgroundEmails are running.
+func inBackground(c echo.Context, job func(ctx context.Context, db *gorm.DB) error) {
+	select {
+	case backgroundEmailSlots <- struct{}{}:
+	default:
+		slog.WarnContext(c.Request().Context(), "could not send email", "error", "too many emails being sent")
+		return
+	}
+
+	backgroundEmails.Add(1)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request().Context()), config.Cfg.Mail.Timeout)
+	go func() {
+		defer backgroundEmails.Done()
+		defer func() { <-backgroundEmailSlots }()
+		defer cancel()
+
+		if err := job(ctx, config.DB.WithContext(ctx)); err != nil {
+			slog.ErrorContext(ctx, "could not send email", "error", err)
+		}
+	}()
+}
+
+// WaitForEmails waits until the emails sent in the background are done, or
+// ctx ends. It is called on shutdown, before the database is closed.
+func WaitForEmails(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		backgroundEmails.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func sendVerificationEmail(ctx context.Context, db *gorm.DB, user models.User) error {
+	if !allowEmail(ctx, user.Email) {
+		return nil
+	}
+
+	token, err := issueUserToken(db, user.ID, models.TokenPurposeVerifyEmail, config.Cfg.Auth.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	return mailer.Default.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: "Hi " + user.Username + ",\n\n" +
+			"Please confirm your email address by opening the link below:\n\n" +
+			emailLink("/verify-email", token) + "\n\n" +
+			"The link expires in " + config.Cfg.Auth.EmailVerificationTTL.String() + ".\n",
+	})
+}
+
+func sendPasswordResetEmail(ctx context.Context, db *gorm.DB, user models.User) error {
+	if !allowEmail(ctx, user.Email) {
+		return nil
+	}
+
+	token, err := issueUserToken(db, user.ID, models.TokenPurposeResetPassword, config.Cfg.Auth.PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	return mailer.Default.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: "Hi " + user.Username + ",\n\n" +
+			"Someone requested a password reset for your account. Open the link below to choose a new password:\n\n" +
+			emailLink("/reset-password", token) + "\n\n" +
+			"The link expires in " + config.Cfg.Auth.PasswordResetTTL.String() + ". If you did not request this, you can ignore this email.\n",
+	})
+}
+
+// allowEmail limits how many emails can be triggered for one address, so the
+// public resend and forgot password endpoints cannot be used to flood an
+// inbox.
+func allowEmail(ctx context.Context, email string) bool {
+	result, err := ratelimit.DefaultStore.Take(ctx, "mail:"+strings.ToLower(email), ratelimit.PerMinute(1, 3))
+	if err != nil {
+		slog.ErrorContext(ctx, "rate limit store unavailable", "error", err)
+		return true
+	}
+	return result.Allowed
+}
+
+func emailLink(path string, token string) string {
+	return strings.TrimRight(config.Cfg.Mail.LinkBaseURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+// issueUserToken creates a token for purpose and invalidates the earlier
+// ones, so only the most recent link works.
+func issueUserToken(db *gorm.DB, userID uint, purpose string, ttl time.Duration) (string, error) {
+	token, err := utils.NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := revokeUserTokens(tx, userID, purpose); err != nil {
+			return err
+		}
+		return tx.Create(&models.UserToken{
+			UserID:    userID,
+			Purpose:   purpose,
+			TokenHash: utils.HashToken(token),
+			ExpiresAt: time.Now().Add(ttl),
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// consumeUserToken marks the token as used and returns it. The conditional
+// update makes sure a token can only be used once, even under concurrent
+// requests.
+func consumeUserToken(tx *gorm.DB, token string, purpose string) (models.UserToken, error) {
+	var userToken models.UserToken
+	hash := utils.HashToken(token)
+	now := time.Now()
+
+	result := tx.Model(&models.UserToken{}).
+		Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", hash, purpose, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return userToken, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return userToken, errInvalidToken
+	}
+
+	if err := tx.Where("token_hash = ?", hash).First(&userToken).Error; err != nil {
+		return userToken, err
+	}
+
+	return userToken, nil
+}
+
+func revokeUserTokens(tx *gorm.DB, userID uint, purpose string) error {
+	return tx.Model(&models.UserToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", time.Now()).Error
+}
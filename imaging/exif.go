@@ -0,0 +1,191 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+var errNoExif = errors.New("no exif data")
+
+// exifTags are the tags kept when metadata is extracted, by IFD.
+var exifTags = map[uint16]map[uint16]string{
+	ifd0: {
+		0x010F: "Make",
+		0x0110: "Model",
+		0x0112: "Orientation",
+		0x0131: "Software",
+		0x0132: "DateTime",
+		0x013B: "Artist",
+		0x8298: "Copyright",
+	},
+	exifIFD: {
+		0x829A: "ExposureTime",
+		0x829D: "FNumber",
+		0x8827: "ISOSpeedRatings",
+		0x9003: "DateTimeOriginal",
+		0x920A: "FocalLength",
+		0xA434: "LensModel",
+	},
+	gpsIFD: {
+		0x0001: "GPSLatitudeRef",
+		0x0002: "GPSLatitude",
+		0x0003: "GPSLongitudeRef",
+		0x0004: "GPSLongitude",
+		0x0005: "GPSAltitudeRef",
+		0x0006: "GPSAltitude",
+	},
+}
+
+// The pointer tags of the sub-IFDs double as their keys in exifTags.
+const (
+	ifd0    = 0
+	exifIFD = 0x8769
+	gpsIFD  = 0x8825
+)
+
+// exifTypeSizes are the sizes in bytes of the TIFF field types.
+var exifTypeSizes = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+
+// parseExif reads the known tags of a TIFF structured EXIF block, as found
+// after the "Exif\0\0" header of a JPEG APP1 segment.
+func parseExif(tiff []byte) (map[string]any, error) {
+	if len(tiff) < 8 {
+		return nil, errNoExif
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, errNoExif
+	}
+
+	tags := map[string]any{}
+	pending := map[uint16]uint32{ifd0: order.Uint32(tiff[4:])}
+	for _, ifd := range []uint16{ifd0, exifIFD, gpsIFD} {
+		offset, ok := pending[ifd]
+		if !ok || uint64(offset)+2 > uint64(len(tiff)) {
+			continue
+		}
+		count := uint32(order.Uint16(tiff[offset:]))
+		for i := uint32(0); i < count; i++ {
+			entry := uint64(offset) + 2 + uint64(i)*12
+			if entry+12 > uint64(len(tiff)) {
+				break
+			}
+			tag := order.Uint16(tiff[entry:])
+			if ifd == ifd0 && (tag == exifIFD || tag == gpsIFD) {
+				pending[tag] = order.Uint32(tiff[entry+8:])
+				continue
+			}
+			name, known := exifTags[ifd][tag]
+			if !known {
+				continue
+			}
+			if value, ok := exifValue(tiff, order, tiff[entry:entry+12]); ok {
+				tags[name] = value
+			}
+		}
+	}
+
+	return tags, nil
+}
+
+// exifValue decodes the value of an IFD entry. Values of a single element
+// are returned as is, longer ones as slices.
+func exifValue(tiff []byte, order binary.ByteOrder, entry []byte) (any, bool) {
+	typ := order.Uint16(entry[2:])
+	count := order.Uint32(entry[4:])
+	size, ok := exifTypeSizes[typ]
+	if !ok || count == 0 || count > 1<<16 {
+		return nil, false
+	}
+
+	data := entry[8:12]
+	if total := uint64(size) * uint64(count); total > 4 {
+		offset := uint64(order.Uint32(entry[8:]))
+		if offset+total > uint64(len(tiff)) {
+			return nil, false
+		}
+		data = tiff[offset : offset+total]
+	}
+
+	if typ == 2 || typ == 7 {
+		return strings.TrimRight(string(bytes.TrimRight(data[:count], "\x00")), " "), true
+	}
+
+	values := make([]any, 0, count)
+	for i := uint32(0); i < count; i++ {
+		v := data[i*size:]
+		switch typ {
+		case 1:
+			values = append(values, v[0])
+		case 3:
+			values = append(values, order.Uint16(v))
+		case 4:
+			values = append(values, order.Uint32(v))
+		case 9:
+			values = append(values, int32(order.Uint32(v)))
+		case 5:
+			values = append(values, rational(float64(order.Uint32(v)), float64(order.Uint32(v[4:]))))
+		case 10:
+			values = append(values, rational(float64(int32(order.Uint32(v))), float64(int32(order.Uint32(v[4:])))))
+		}
+	}
+	if len(values) == 1 {
+		return values[0], true
+	}
+	return values, true
+}
+
+func rational(num, denom float64) float64 {
+	if denom == 0 {
+		return 0
+	}
+	return num / denom
+}
+
+// jpegExif returns the EXIF block of a JPEG.
+func jpegExif(data []byte) []byte {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		// Start of scan: only image data follows.
+		if marker == 0xDA {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			break
+		}
+		if payload := data[i+4 : end]; marker == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			return payload[6:]
+		}
+		i = end
+	}
+	return nil
+}
+
+// pngExif returns the content of the eXIf chunk of a PNG.
+func pngExif(data []byte) []byte {
+	const signatureLen = 8
+	for i := signatureLen; i+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			break
+		}
+		if string(data[i+4:i+8]) == "eXIf" {
+			return data[i+8 : i+8+length]
+		}
+		i = end
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// ErrUnsupported is returned for content no registered decoder understands.
+var ErrUnsupported = errors.New("image format is not supported")
+
+const jpegQuality = 85
+
+// Dimensions returns the pixel size of an image without decoding all of it.
+func Dimensions(data []byte) (width, height int, err error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, invalid(err)
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// Resized is an encoded resized image.
+type Resized struct {
+	Data        []byte
+	ContentType string
+	Width       int
+	Height      int
+}
+
+// Resize scales the image down to fit within maxWidth x maxHeight, keeping
+// its aspect ratio. Images that already fit are re-encoded but not scaled
+// up. PNG, GIF and WebP sources are encoded as PNG to keep transparency,
+// everything else as JPEG. Images of more than maxPixels are rejected before
+// they are decoded.
+func Resize(data []byte, maxWidth, maxHeight int, maxPixels int64) (Resized, error) {
+	width, height, err := Dimensions(data)
+	if err != nil {
+		return Resized{}, err
+	}
+	if int64(width)*int64(height) > maxPixels {
+		return Resized{}, ErrTooManyPixels
+	}
+
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Resized{}, invalid(err)
+	}
+
+	bounds := src.Bounds()
+	width, height = fit(bounds.Dx(), bounds.Dy(), maxWidth, maxHeight)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+
+	var buf bytes.Buffer
+	resized := Resized{Width: width, Height: height}
+	if format == "png" || format == "gif" || format == "webp" {
+		resized.ContentType = "image/png"
+		err = png.Encode(&buf, dst)
+	} else {
+		resized.ContentType = "image/jpeg"
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: jpegQuality})
+	}
+	resized.Data = buf.Bytes()
+
+	return resized, err
+}
+
+// fit returns the largest size with the aspect ratio of width x height that
+// fits within maxWidth x maxHeight, never larger than the original. A zero
+// bound does not constrain that side.
+func fit(width, height, maxWidth, maxHeight int) (int, int) {
+	scale := 1.0
+	if maxWidth > 0 && width > maxWidth {
+		scale = float64(maxWidth) / float64(width)
+	}
+	if maxHeight > 0 && height > maxHeight {
+		scale = min(scale, float64(maxHeight)/float64(height))
+	}
+
+	return max(1, int(float64(width)*scale+0.5)), max(1, int(float64(height)*scale+0.5))
+}
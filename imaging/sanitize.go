@@ -0,0 +1,258 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+var (
+	// ErrTooManyPixels is returned for images whose dimensions exceed the
+	// limit, before they are decoded.
+	ErrTooManyPixels = errors.New("image has too many pixels")
+	// ErrInvalid is returned for images that cannot be decoded.
+	ErrInvalid = errors.New("image could not be decoded")
+)
+
+// sanitizeQuality is the JPEG quality of re-encoded originals, higher than
+// that of variants since they replace the upload.
+const sanitizeQuality = 92
+
+// Sanitized is an image with its metadata removed.
+type Sanitized struct {
+	Data []byte
+	// Metadata holds the known EXIF tags of the original by name, or nil if
+	// it had none.
+	Metadata map[string]any
+}
+
+// Sanitize removes the metadata from an image, such as the EXIF location of
+// photos. JPEG, PNG and GIF images are decoded and re-encoded, with JPEGs
+// turned upright according to their EXIF orientation. WebP images, which
+// cannot be encoded, have their metadata chunks removed instead. Images of
+// more than maxPixels, counting every frame of animated GIFs, are rejected
+// before they are decoded.
+func Sanitize(data []byte, maxPixels int64) (Sanitized, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Sanitized{}, invalid(err)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return Sanitized{}, ErrTooManyPixels
+	}
+
+	var sanitized Sanitized
+	var buf bytes.Buffer
+	switch format {
+	case "jpeg":
+		sanitized.Metadata, _ = parseExif(jpegExif(data))
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return sanitized, invalid(err)
+		}
+		if orientation, ok := sanitized.Metadata["Orientation"].(uint16); ok {
+			img = orient(img, int(orientation))
+		}
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: sanitizeQuality})
+		sanitized.Data = buf.Bytes()
+		return sanitized, err
+
+	case "png":
+		sanitized.Metadata, _ = parseExif(pngExif(data))
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return sanitized, invalid(err)
+		}
+		err = png.Encode(&buf, img)
+		sanitized.Data = buf.Bytes()
+		return sanitized, err
+
+	case "gif":
+		// Every frame is decoded, so the limit applies to all of them
+		// together.
+		pixels, err := gifPixels(data)
+		if err != nil {
+			return sanitized, err
+		}
+		if pixels > maxPixels {
+			return sanitized, ErrTooManyPixels
+		}
+		// Re-encoding keeps the animation but drops comments and
+		// application extensions.
+		img, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return sanitized, invalid(err)
+		}
+		err = gif.EncodeAll(&buf, img)
+		sanitized.Data = buf.Bytes()
+		return sanitized, err
+
+	case "webp":
+		sanitized.Data, sanitized.Metadata, err = stripWebP(data)
+		return sanitized, err
+
+	default:
+		return sanitized, ErrUnsupported
+	}
+}
+
+func invalid(err error) error {
+	if errors.Is(err, image.ErrFormat) {
+		return ErrUnsupported
+	}
+	return fmt.Errorf("%w: %w", ErrInvalid, err)
+}
+
+// orient turns img upright according to an EXIF orientation between 1 and 8.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	w, h := b.Dx(), b.Dy()
+	// Orientations 5 to 8 swap width and height.
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(x, y):][:4])
+		}
+	}
+
+	return dst
+}
+
+// gifPixels returns the total number of pixels of the frames of a GIF, read
+// from their image descriptors without decoding them.
+func gifPixels(data []byte) (int64, error) {
+	const headerLen = 13
+	if len(data) < headerLen {
+		return 0, ErrInvalid
+	}
+	i := headerLen
+	// The global color table follows the header if flagged.
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << (flags&0x07 + 1)
+	}
+
+	// skipSubBlocks returns the index after the sub-blocks starting at i.
+	skipSubBlocks := func(i int) (int, error) {
+		for i < len(data) {
+			size := int(data[i])
+			i++
+			if size == 0 {
+				return i, nil
+			}
+			i += size
+		}
+		return 0, ErrInvalid
+	}
+
+	var pixels int64
+	for i < len(data) {
+		var err error
+		switch data[i] {
+		case 0x21: // extension: label and sub-blocks
+			if i+2 > len(data) {
+				return 0, ErrInvalid
+			}
+			i, err = skipSubBlocks(i + 2)
+		case 0x2C: // image descriptor, then color table and image data
+			if i+10 > len(data) {
+				return 0, ErrInvalid
+			}
+			width := int64(binary.LittleEndian.Uint16(data[i+5:]))
+			height := int64(binary.LittleEndian.Uint16(data[i+7:]))
+			pixels += width * height
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+			// The LZW minimum code size precedes the data sub-blocks.
+			i, err = skipSubBlocks(i + 1)
+		case 0x3B: // trailer
+			return pixels, nil
+		default:
+			return 0, ErrInvalid
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	// The decoder accepts files cut off after the last frame.
+	return pixels, nil
+}
+
+// stripWebP removes the EXIF and XMP chunks from a WebP image and returns
+// the known tags of the EXIF chunk.
+func stripWebP(data []byte) ([]byte, map[string]any, error) {
+	const headerLen = 12
+	if len(data) < headerLen || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, nil, ErrInvalid
+	}
+
+	var metadata map[string]any
+	out := append([]byte{}, data[:headerLen]...)
+	for i := headerLen; i < len(data); {
+		if i+8 > len(data) {
+			return nil, nil, ErrInvalid
+		}
+		fourCC := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2
+		if size < 0 || end > len(data) {
+			return nil, nil, ErrInvalid
+		}
+
+		switch fourCC {
+		case "EXIF":
+			metadata, _ = parseExif(bytes.TrimPrefix(data[i+8:i+8+size], []byte("Exif\x00\x00")))
+		case "XMP ":
+		case "VP8X":
+			chunk := append([]byte{}, data[i:end]...)
+			// Clear the EXIF and XMP flags of the extended header.
+			if len(chunk) > 8 {
+				chunk[8] &^= 0x08 | 0x04
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, metadata, nil
+}
@@ -0,0 +1,215 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func encodeJPEG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withExif inserts an APP1 segment after the start of a JPEG, holding an
+// orientation and a camera model.
+func withExif(data []byte, orientation uint16, model string) []byte {
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	// IFD0 with two entries and no next IFD; the model follows it.
+	modelOffset := uint32(8 + 2 + 2*12 + 4)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 2)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 0x0110)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 2)
+	tiff = binary.LittleEndian.AppendUint32(tiff, uint32(len(model)+1))
+	tiff = binary.LittleEndian.AppendUint32(tiff, modelOffset)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 0x0112)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 3)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+	tiff = append(tiff, model...)
+	tiff = append(tiff, 0)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+// encodeGIF returns an animation of frames frames of width by height.
+func encodeGIF(t *testing.T, width, height, frames int) []byte {
+	t.Helper()
+	palette := color.Palette{color.Black, color.White}
+	anim := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, width, height), palette))
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSanitizeRejectsTooManyPixels(t *testing.T) {
+	if _, err := Sanitize(encodeJPEG(t, 100, 100), 100*100-1); !errors.Is(err, ErrTooManyPixels) {
+		t.Errorf("error = %v, want %v", err, ErrTooManyPixels)
+	}
+	if _, err := Sanitize(encodeJPEG(t, 100, 100), 100*100); err != nil {
+		t.Errorf("image at the limit rejected: %v", err)
+	}
+}
+
+func TestSanitizeCountsEveryGIFFrame(t *testing.T) {
+	// Every frame is within the limit on its own, but not all together.
+	data := encodeGIF(t, 50, 50, 10)
+
+	if _, err := Sanitize(data, 50*50*10-1); !errors.Is(err, ErrTooManyPixels) {
+		t.Errorf("error = %v, want %v", err, ErrTooManyPixels)
+	}
+	sanitized, err := Sanitize(data, 50*50*10)
+	if err != nil {
+		t.Fatalf("animation at the limit rejected: %v", err)
+	}
+	anim, err := gif.DecodeAll(bytes.NewReader(sanitized.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anim.Image) != 10 {
+		t.Errorf("frames = %d, want 10", len(anim.Image))
+	}
+}
+
+func TestGIFPixels(t *testing.T) {
+	pixels, err := gifPixels(encodeGIF(t, 30, 20, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pixels != 30*20*3 {
+		t.Errorf("pixels = %d, want %d", pixels, 30*20*3)
+	}
+
+	data := encodeGIF(t, 30, 20, 1)
+	for _, bad := range [][]byte{
+		data[:10],
+		append(append([]byte{}, data[:len(data)-1]...), 0x00),
+	} {
+		if _, err := gifPixels(bad); !errors.Is(err, ErrInvalid) {
+			t.Errorf("error = %v, want %v", err, ErrInvalid)
+		}
+	}
+}
+
+func TestSanitizeStripsJPEGMetadata(t *testing.T) {
+	// Orientation 6 turns the image a quarter to the right.
+	data := withExif(encodeJPEG(t, 40, 20), 6, "Test Camera")
+
+	sanitized, err := Sanitize(data, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sanitized.Metadata["Orientation"]; got != uint16(6) {
+		t.Errorf("orientation = %v, want 6", got)
+	}
+	if got := sanitized.Metadata["Model"]; got != "Test Camera" {
+		t.Errorf("model = %v, want Test Camera", got)
+	}
+	if bytes.Contains(sanitized.Data, []byte("Exif")) || bytes.Contains(sanitized.Data, []byte("Test Camera")) {
+		t.Error("metadata left in the sanitized image")
+	}
+
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(sanitized.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 20 || cfg.Height != 40 {
+		t.Errorf("size = %dx%d, want 20x40 after turning upright", cfg.Width, cfg.Height)
+	}
+}
+
+func TestSanitizeReencodesPNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	// A text chunk before the end of the image, as editors add.
+	data := buf.Bytes()
+	iend := len(data) - 12
+	text := []byte("tEXtComment\x00secret")
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(text)-4))
+	chunk = append(chunk, text...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(text))
+	data = append(append(append([]byte{}, data[:iend]...), chunk...), data[iend:]...)
+
+	sanitized, err := Sanitize(data, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sanitized.Data, []byte("secret")) {
+		t.Error("text chunk left in the sanitized image")
+	}
+}
+
+func TestSanitizeRejectsGarbage(t *testing.T) {
+	if _, err := Sanitize([]byte("not an image at all"), 1<<20); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("error = %v, want %v", err, ErrUnsupported)
+	}
+}
+
+func TestStripWebP(t *testing.T) {
+	chunk := func(fourCC string, payload []byte) []byte {
+		out := append([]byte(fourCC), binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))...)
+		out = append(out, payload...)
+		if len(payload)%2 == 1 {
+			out = append(out, 0)
+		}
+		return out
+	}
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x08 | 0x04
+	body := []byte("WEBP")
+	body = append(body, chunk("VP8X", vp8x)...)
+	body = append(body, chunk("VP8L", []byte("pixels"))...)
+	body = append(body, chunk("EXIF", []byte("Exif\x00\x00secret"))...)
+	body = append(body, chunk("XMP ", []byte("<x:xmpmeta>secret</x:xmpmeta>"))...)
+	data := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	data = append(data, body...)
+
+	out, _, err := stripWebP(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out, []byte("secret")) {
+		t.Error("metadata chunks left in the image")
+	}
+	if !bytes.Contains(out, []byte("pixels")) {
+		t.Error("image chunk removed")
+	}
+	if flags := out[12+8]; flags&(0x08|0x04) != 0 {
+		t.Errorf("VP8X flags = %#x, want the EXIF and XMP flags cleared", flags)
+	}
+	if size := binary.LittleEndian.Uint32(out[4:]); int(size) != len(out)-8 {
+		t.Errorf("RIFF size = %d, want %d", size, len(out)-8)
+	}
+
+	if _, _, err := stripWebP(data[:len(data)-3]); !errors.Is(err, ErrInvalid) {
+		t.Errorf("truncated image: error = %v, want %v", err, ErrInvalid)
+	}
+}
@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	gormLogger "gorm.io/gorm/logger"
+)
+
+// GormLogger sends GORM logs through slog so that queries are correlated
+// with the request that issued them.
+type GormLogger struct {
+	Logger        *slog.Logger
+	SlowThreshold time.Duration
+	LogLevel      gormLogger.LogLevel
+}
+
+func (l GormLogger) LogMode(level gormLogger.LogLevel) gormLogger.Interface {
+	l.LogLevel = level
+	return l
+}
+
+func (l GormLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	if l.LogLevel >= gormLogger.Info {
+		l.Logger.InfoContext(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l GormLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	if l.LogLevel >= gormLogger.Warn {
+		l.Logger.WarnContext(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l GormLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	if l.LogLevel >= gormLogger.Error {
+		l.Logger.ErrorContext(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l GormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.LogLevel <= gormLogger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && l.LogLevel >= gormLogger.Error:
+		sql, rows := fc()
+		l.Logger.ErrorContext(ctx, "database query failed", "error", err, "sql", sql, "rows", rows, "duration_ms", elapsed.Milliseconds())
+	case l.SlowThreshold > 0 && elapsed > l.SlowThreshold && l.LogLevel >= gormLogger.Warn:
+		sql, rows := fc()
+		l.Logger.WarnContext(ctx, "slow database query", "sql", sql, "rows", rows, "duration_ms", elapsed.Milliseconds())
+	case l.LogLevel >= gormLogger.Info:
+		sql, rows := fc()
+		l.Logger.DebugContext(ctx, "database query", "sql", sql, "rows", rows, "duration_ms", elapsed.Milliseconds())
+	}
+}
@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type contextKey struct{}
+
+// requestInfo carries the correlation fields of a request. It is stored as a
+// pointer so that middleware running after the request ID is assigned, such
+// as authentication, can attach the user ID without replacing the context.
+type requestInfo struct {
+	requestID string
+	userID    uint
+}
+
+// WithRequestID returns a context whose log records carry the request ID.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, &requestInfo{requestID: requestID})
+}
+
+// RequestID returns the request ID stored in ctx, if any.
+func RequestID(ctx context.Context) string {
+	if info, ok := ctx.Value(contextKey{}).(*requestInfo); ok {
+		return info.requestID
+	}
+	return ""
+}
+
+// SetUserID attaches the authenticated user to every later log record of the
+// request.
+func SetUserID(ctx context.Context, userID uint) {
+	if info, ok := ctx.Value(contextKey{}).(*requestInfo); ok {
+		info.userID = userID
+	}
+}
+
+// New creates a logger writing to w in the given format ("json" or "text")
+// that adds the request ID, user ID and trace ID found in the context to
+// each record.
+func New(w io.Writer, format string, level slog.Level) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if strings.EqualFold(format, "text") {
+		handler = slog.NewTextHandler(w, opts)
+	} else {
+		handler = slog.NewJSONHandler(w, opts)
+	}
+
+	return slog.New(contextHandler{handler})
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if info, ok := ctx.Value(contextKey{}).(*requestInfo); ok {
+		r.AddAttrs(slog.String("request_id", info.requestID))
+		if info.userID != 0 {
+			r.AddAttrs(slog.Uint64("user_id", uint64(info.userID)))
+		}
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,43 @@
+package mailer
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// LogMailer records emails in the application log instead of sending them.
+// Only the recipient and subject are logged: bodies hold verification and
+// reset links, which would let anyone reading the log take over accounts.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, msg Message) error {
+	if err := sanitize(msg); err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "email", "to", msg.To, "subject", msg.Subject, "body", "[redacted]")
+	return nil
+}
+
+// FileMailer writes each email as an .eml file into Dir, so that links in
+// verification and reset emails can be followed while testing locally.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m FileMailer) Send(_ context.Context, msg Message) error {
+	if err := sanitize(msg); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+
+	name := strconv.FormatInt(time.Now().UnixNano(), 10) + ".eml"
+	return os.WriteFile(filepath.Join(m.Dir, name), format(m.From, msg), 0o600)
+}
@@ -1,13 +1,33 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"io/fs"
 	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 	"todo-app/config"
+	"todo-app/controllers"
+	"todo-app/logging"
+	"todo-app/mailer"
+	"todo-app/middleware"
+	"todo-app/ratelimit"
 	"todo-app/routes"
+	"todo-app/scanner"
+	"todo-app/signing"
+	"todo-app/tracing"
 
 	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
 	echoMiddleware "github.com/labstack/echo/v4/middleware"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
 )
 
 // @host localhost:8000
@@ -18,20 +38,190 @@ import (
 // @name Authorization
 // @description In value field type "Bearer" followed by a space and the JWT token. Otherwise you won't get authtorized, example : (Bearer <your-token>)
 func main() {
-	err := godotenv.Load()
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatal("Error loading .env file: ", err)
+	}
+
+	cfg, err := config.Load(os.Args[1:])
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		log.Fatal(err)
+	}
+	config.Cfg = cfg
+
+	slog.SetDefault(logging.New(os.Stdout, cfg.Log.Format, cfg.Log.SlogLevel()))
+
+	shutdownTracing := func(context.Context) error { return nil }
+	if cfg.Tracing.Enabled {
+		shutdownTracing, err = tracing.Setup(context.Background(), tracing.Options{
+			ServiceName: cfg.Tracing.ServiceName,
+			Exporter:    cfg.Tracing.Exporter,
+			Protocol:    cfg.Tracing.Protocol,
+			Endpoint:    cfg.Tracing.Endpoint,
+			Insecure:    cfg.Tracing.Insecure,
+			SampleRatio: cfg.Tracing.SampleRatio,
+		})
+		if err != nil {
+			log.Fatal("Failed to set up tracing: ", err)
+		}
 	}
 
 	e := echo.New()
-	e.Use(echoMiddleware.LoggerWithConfig(echoMiddleware.LoggerConfig{
-		Format: "${time_rfc3339} | ${method} | ${uri} | ${status} | ${latency_human} \n",
-	}))
+	e.HideBanner = true
+	e.HidePort = true
+	e.IPExtractor = ipExtractor(cfg.Server.TrustedProxies)
+	if cfg.Tracing.Enabled {
+		e.Use(otelecho.Middleware(cfg.Tracing.ServiceName))
+	}
+	e.Use(middleware.RequestIDMiddleware)
+	e.Use(middleware.LoggerMiddleware)
+	if cfg.Metrics.Enabled {
+		e.Use(middleware.MetricsMiddleware)
+	}
+	if cfg.CORS.Enabled {
+		e.Use(echoMiddleware.CORSWithConfig(echoMiddleware.CORSConfig{
+			AllowOrigins:     cfg.CORS.AllowOrigins,
+			AllowMethods:     cfg.CORS.AllowMethods,
+			AllowHeaders:     cfg.CORS.AllowHeaders,
+			AllowCredentials: cfg.CORS.AllowCredentials,
+			MaxAge:           cfg.CORS.MaxAge,
+		}))
+	}
 
 	config.Connect()
 	config.Migrate()
+	controllers.RegisterReadinessCheck("database", config.Ping)
+
+	if err := config.PromoteAdmins(); err != nil {
+		log.Fatal("Failed to promote admins: ", err)
+	}
+	if err := signing.Rotate(context.Background()); err != nil {
+		log.Fatal("Failed to set up signing keys: ", err)
+	}
+
+	switch cfg.Mail.Driver {
+	case "smtp":
+		mailer.Default = mailer.SMTPMailer{
+			Host:     cfg.Mail.SMTPHost,
+			Port:     cfg.Mail.SMTPPort,
+			Username: cfg.Mail.SMTPUsername,
+			Password: cfg.Mail.SMTPPassword,
+			From:     cfg.Mail.From,
+		}
+	case "file":
+		mailer.Default = mailer.FileMailer{Dir: cfg.Mail.Dir, From: cfg.Mail.From}
+	default:
+		mailer.Default = mailer.LogMailer{}
+	}
+
+	switch cfg.Scan.Driver {
+	case "clamav":
+		clamav := scanner.ClamAV{
+			Network: cfg.Scan.ClamAVNetwork,
+			Address: cfg.Scan.ClamAVAddress,
+			Timeout: cfg.Scan.Timeout,
+		}
+		scanner.Default = clamav
+		controllers.RegisterReadinessCheck("scanner", clamav.Ping)
+	case "eicar":
+		scanner.Default = scanner.EICAR{}
+	default:
+		scanner.Default = scanner.Noop{}
+	}
+
+	switch cfg.RateLimit.Backend {
+	case "redis":
+		store := ratelimit.NewRedisStore(redis.NewClient(&redis.Options{
+			Addr:     cfg.RateLimit.RedisAddr,
+			Password: cfg.RateLimit.RedisPassword,
+			DB:       cfg.RateLimit.RedisDB,
+		}), "ratelimit:")
+		ratelimit.DefaultStore = store
+		controllers.RegisterReadinessCheck("ratelimit", store.Ping)
+	default:
+		ratelimit.DefaultStore = ratelimit.NewMemoryStore()
+	}
 
 	routes.SetupRoutes(e)
 
-	e.Logger.Fatal(e.Start(":8000"))
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go runPeriodically(ctx, time.Hour, "purge deleted accounts", controllers.PurgeScheduledAccounts)
+	go runPeriodically(ctx, time.Hour, "rotate signing keys", signing.Rotate)
+	go runPeriodically(ctx, time.Hour, "purge orphaned image blobs", controllers.PurgeOrphanedImageBlobs)
+	go runPeriodically(ctx, time.Hour, "purge expired uploads", controllers.PurgeExpiredUploads)
+	go runPeriodically(ctx, time.Minute, "scan pending attachments", controllers.ScanPendingImages)
+
+	go func() {
+		slog.Info("starting server", "addr", cfg.Server.Addr())
+		if err := e.Start(cfg.Server.Addr()); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("server error", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+
+	// Fail readiness first so the load balancer stops routing new requests,
+	// then let in-flight requests such as uploads finish.
+	controllers.MarkShuttingDown()
+	slog.Info("shutting down", "delay", cfg.Server.ShutdownDelay.String(), "timeout", cfg.Server.ShutdownTimeout.String())
+	time.Sleep(cfg.Server.ShutdownDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		slog.Error("failed to shut down gracefully", "error", err)
+	}
+	if err := controllers.WaitForEmails(shutdownCtx); err != nil {
+		slog.Error("failed to finish sending emails", "error", err)
+	}
+
+	if err := config.Close(); err != nil {
+		slog.Error("failed to close database", "error", err)
+	}
+
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("failed to flush traces", "error", err)
+	}
+}
+
+// ipExtractor returns how client IPs, used for rate limiting and the audit
+// log, are determined. X-Forwarded-For is only believed when it was set by
+// one of the trusted proxies, so that clients cannot pick their own IP.
+func ipExtractor(trustedProxies []string) echo.IPExtractor {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, cidr := range trustedProxies {
+		// The ranges have been validated with the configuration.
+		_, ipNet, _ := net.ParseCIDR(cidr)
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
+// runPeriodically runs job every interval until ctx is cancelled.
+func runPeriodically(ctx context.Context, interval time.Duration, name string, job func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := job(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("background job failed", "job", name, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	HTTPRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Number of HTTP requests by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by method, route template and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Duration of GORM operations by operation and table.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation", "table"})
+
+	DBQueryErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "db_query_errors_total",
+		Help: "Number of failed GORM operations by operation and table.",
+	}, []string{"operation", "table"})
+
+	ImageUploadBytesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "image_upload_bytes_total",
+		Help: "Total bytes of uploaded images.",
+	})
+
+	ImageUploadSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "image_upload_size_bytes",
+		Help:    "Size distribution of uploaded images.",
+		Buckets: prometheus.ExponentialBuckets(16*1024, 4, 7),
+	})
+
+	ImageUploadDeduplicatedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "image_upload_deduplicated_total",
+		Help: "Number of uploaded images whose content was already stored.",
+	})
+
+	AttachmentScansTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "attachment_scans_total",
+		Help: "Number of malware scans of attachments by result.",
+	}, []string{"result"})
+
+	TasksCreatedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tasks_created_total",
+		Help: "Number of tasks created.",
+	})
+
+	TasksCompletedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tasks_completed_total",
+		Help: "Number of tasks marked as completed.",
+	})
+)
+
+// ObserveImageUpload records the size of an uploaded image.
+func ObserveImageUpload(size int) {
+	ImageUploadBytesTotal.Add(float64(size))
+	ImageUploadSize.Observe(float64(size))
+}
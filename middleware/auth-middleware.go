@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"todo-app/config"
+	"todo-app/logging"
+	"todo-app/models"
+	"todo-app/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// lastUsedResolution limits how often the last use of a personal access
+// token is written, so that busy scripts do not cause a write per request.
+const lastUsedResolution = time.Minute
+
+// AuthMiddleware accepts personal access tokens alongside the session JWTs of
+// JWTMiddleware. Combine it with RequireScope to limit what tokens can do.
+func AuthMiddleware() echo.MiddlewareFunc {
+	jwtAuth := JWTMiddleware()
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		withJWT := jwtAuth(next)
+
+		return func(c echo.Context) error {
+			raw, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+			if !ok || !strings.HasPrefix(raw, models.PersonalAccessTokenPrefix) {
+				return withJWT(c)
+			}
+
+			token, err := findPersonalAccessToken(c, raw)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired token")
+			}
+			if err != nil {
+				return utils.InternalServerError(c, "could not verify token", err)
+			}
+
+			// Handlers read the user from the same place for both kinds of
+			// credentials.
+			c.Set("user", &jwt.Token{
+				Valid: true,
+				Claims: &utils.Claims{
+					RegisteredClaims: jwt.RegisteredClaims{
+						Subject: strconv.FormatUint(uint64(token.UserID), 10),
+					},
+					PersonalAccessToken: true,
+					Scopes:              token.ScopeList(),
+				},
+			})
+			logging.SetUserID(c.Request().Context(), token.UserID)
+
+			return next(c)
+		}
+	}
+}
+
+func findPersonalAccessToken(c echo.Context, raw string) (models.PersonalAccessToken, error) {
+	db := config.DB.WithContext(c.Request().Context())
+	now := time.Now()
+	var token models.PersonalAccessToken
+
+	err := db.Joins("User").
+		Where("token_hash = ?", utils.HashToken(raw)).
+		Where("(personal_access_tokens.expires_at IS NULL OR personal_access_tokens.expires_at > ?)", now).
+		Where(`"User".deletion_scheduled_at IS NULL AND "User".disabled_at IS NULL`).
+		First(&token).Error
+	if err != nil {
+		return token, err
+	}
+
+	err = db.Model(&models.PersonalAccessToken{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", token.ID, now.Add(-lastUsedResolution)).
+		Update("last_used_at", now).Error
+
+	return token, err
+}
+
+// RequireScope rejects personal access tokens without scope. Session JWTs
+// are allowed everything.
+func RequireScope(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, err := utils.GetClaims(c)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, "missing credentials")
+			}
+			if claims.PersonalAccessToken && !slices.Contains(claims.Scopes, scope) {
+				return c.JSON(http.StatusForbidden, map[string]string{
+					"message": "token is missing the " + scope + " scope",
+				})
+			}
+
+			return next(c)
+		}
+	}
+}
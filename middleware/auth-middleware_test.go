@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"todo-app/models"
+	"todo-app/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+func TestRequireScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims *utils.Claims
+		status int
+	}{
+		{
+			name:   "session token",
+			claims: &utils.Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "1"}},
+			status: http.StatusOK,
+		},
+		{
+			name: "token with the scope",
+			claims: &utils.Claims{
+				RegisteredClaims:    jwt.RegisteredClaims{Subject: "1"},
+				PersonalAccessToken: true,
+				Scopes:              []string{models.ScopeTasksRead, models.ScopeTasksWrite},
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "token without the scope",
+			claims: &utils.Claims{
+				RegisteredClaims:    jwt.RegisteredClaims{Subject: "1"},
+				PersonalAccessToken: true,
+				Scopes:              []string{models.ScopeTasksRead},
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "token without scopes",
+			claims: &utils.Claims{
+				RegisteredClaims:    jwt.RegisteredClaims{Subject: "1"},
+				PersonalAccessToken: true,
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "no credentials",
+			status: http.StatusUnauthorized,
+		},
+	}
+
+	e := echo.New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c := e.NewContext(httptest.NewRequest(http.MethodPost, "/api/tasks", nil), rec)
+			if tt.claims != nil {
+				c.Set("user", &jwt.Token{Valid: true, Claims: tt.claims})
+			}
+
+			called := false
+			handler := RequireScope(models.ScopeTasksWrite)(func(c echo.Context) error {
+				called = true
+				return c.NoContent(http.StatusOK)
+			})
+			err := handler(c)
+
+			status := rec.Code
+			if he, ok := err.(*echo.HTTPError); ok {
+				status = he.Code
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.status {
+				t.Errorf("status = %d, want %d", status, tt.status)
+			}
+			if called != (tt.status == http.StatusOK) {
+				t.Errorf("handler called = %v", called)
+			}
+		})
+	}
+}
@@ -1,26 +1,46 @@
 package middleware
 
 import (
+	"fmt"
+	"mime"
 	"net/http"
+	"todo-app/config"
 
 	"github.com/labstack/echo/v4"
 )
 
+// multipartOverhead allows for the boundaries, part headers and small fields
+// around the file in a multipart body.
+const multipartOverhead = 64 * 1024
+
+// ImageUploadMiddleware rejects requests that are not multipart forms and
+// caps the body at the largest attachment size, so that the handler can
+// stream the file. The file itself is sniffed and checked against the limit
+// of its type while it is read.
 func ImageUploadMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		file, err := c.FormFile("image")
-		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, "No file uploaded")
-		}
+		return limitMultipart(c, next, config.Cfg.Upload.LargestSize()+multipartOverhead)
+	}
+}
 
-		if file.Size > 10*1024*1024 {
-			return echo.NewHTTPError(http.StatusBadRequest, "File exceeds 10 MB limit")
-		}
+// BatchUploadMiddleware is ImageUploadMiddleware for multi-file uploads,
+// capping the body at upload.max_batch_size.
+func BatchUploadMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return limitMultipart(c, next, config.Cfg.Upload.MaxBatchSize+int64(config.Cfg.Upload.MaxBatchFiles)*multipartOverhead)
+	}
+}
 
-		if file.Header.Get("Content-Type") != "image/jpeg" && file.Header.Get("Content-Type") != "image/png" {
-			return echo.NewHTTPError(http.StatusBadRequest, "Invalid image format, only JPEG and PNG are allowed")
-		}
+func limitMultipart(c echo.Context, next echo.HandlerFunc, maxSize int64) error {
+	mediaType, _, err := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	if err != nil || mediaType != echo.MIMEMultipartForm {
+		return echo.NewHTTPError(http.StatusBadRequest, "No file uploaded")
+	}
 
-		return next(c)
+	if c.Request().ContentLength > maxSize {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("File exceeds %d bytes limit", maxSize))
 	}
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, maxSize)
+
+	return next(c)
 }
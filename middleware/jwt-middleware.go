@@ -1,17 +1,67 @@
 package middleware
 
 import (
-	"log"
-	"os"
+	"net/http"
+	"time"
+	"todo-app/config"
+	"todo-app/logging"
+	"todo-app/models"
+	"todo-app/signing"
+	"todo-app/utils"
 
+	"github.com/golang-jwt/jwt/v5"
 	echojwt "github.com/labstack/echo-jwt/v4"
 	"github.com/labstack/echo/v4"
 )
 
 func JWTMiddleware() echo.MiddlewareFunc {
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("Failed to get JWT SECRET")
+	parser := jwt.NewParser(
+		jwt.WithIssuer(config.Cfg.Auth.JWTIssuer),
+		jwt.WithAudience(config.Cfg.Auth.JWTAudience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+
+	jwtAuth := echojwt.WithConfig(echojwt.Config{
+		ParseTokenFunc: func(c echo.Context, auth string) (interface{}, error) {
+			return parser.ParseWithClaims(auth, &utils.Claims{}, signing.Keyfunc)
+		},
+		SuccessHandler: func(c echo.Context) {
+			logging.SetUserID(c.Request().Context(), utils.GetUserID(c))
+		},
+	})
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return jwtAuth(requireActiveSession(next))
+	}
+}
+
+// requireActiveSession rejects tokens whose session has been revoked, e.g.
+// after a password change, or whose user has been disabled or deleted.
+func requireActiveSession(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, err := utils.GetClaims(c)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired jwt")
+		}
+		userID, err := claims.UserID()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired jwt")
+		}
+
+		var count int64
+		err = config.DB.WithContext(c.Request().Context()).Model(&models.Session{}).
+			Joins("JOIN users ON users.id = sessions.user_id").
+			Where("sessions.id = ? AND sessions.user_id = ? AND sessions.revoked_at IS NULL AND sessions.expires_at > ?", claims.SessionID, userID, time.Now()).
+			Where("users.disabled_at IS NULL AND users.deletion_scheduled_at IS NULL").
+			Count(&count).Error
+		if err != nil {
+			return utils.InternalServerError(c, "could not verify session", err)
+		}
+		if count == 0 {
+			return echo.NewHTTPError(http.StatusUnauthorized, "session has expired or been revoked")
+		}
+
+		return next(c)
 	}
-	return echojwt.JWT([]byte(jwtSecret))
 }
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// LoggerMiddleware writes one structured log record per request.
+func LoggerMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+		if err != nil {
+			c.Error(err)
+		}
+
+		req := c.Request()
+		res := c.Response()
+		level := slog.LevelInfo
+		if res.Status >= 500 {
+			level = slog.LevelError
+		}
+
+		slog.Log(req.Context(), level, "request",
+			"method", req.Method,
+			"uri", req.RequestURI,
+			"route", c.Path(),
+			"status", res.Status,
+			"latency_ms", time.Since(start).Milliseconds(),
+			"bytes_out", res.Size,
+			"remote_ip", c.RealIP(),
+		)
+
+		return nil
+	}
+}
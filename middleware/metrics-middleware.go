@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+	"todo-app/metrics"
+
+	"github.com/labstack/echo/v4"
+)
+
+// MetricsMiddleware records request counts and latency labelled by the route
+// template (e.g. /api/tasks/:id) rather than the raw URI, keeping the label
+// cardinality bounded.
+func MetricsMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+		if err != nil {
+			c.Error(err)
+		}
+
+		route := c.Path()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Response().Status)
+
+		metrics.HTTPRequestsTotal.WithLabelValues(c.Request().Method, route, status).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(c.Request().Method, route, status).Observe(time.Since(start).Seconds())
+
+		return nil
+	}
+}
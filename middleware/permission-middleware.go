@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+	"todo-app/config"
+	"todo-app/models"
+	"todo-app/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RequirePermission rejects users whose role does not grant permission. The
+// role is read from the database so that changes apply immediately. It must
+// run after JWTMiddleware.
+func RequirePermission(permission string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			var user models.User
+			err := config.DB.WithContext(c.Request().Context()).
+				Select("id", "role").
+				First(&user, utils.GetUserID(c)).Error
+			if err != nil {
+				return utils.InternalServerError(c, "could not check permissions", err)
+			}
+
+			if !models.HasPermission(user.Role, permission) {
+				return c.JSON(http.StatusForbidden, map[string]string{
+					"message": "permission denied",
+				})
+			}
+
+			return next(c)
+		}
+	}
+}
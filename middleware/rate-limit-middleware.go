@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"log/slog"
+	"strconv"
+	"todo-app/config"
+	"todo-app/ratelimit"
+	"todo-app/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RateLimitByIP limits requests per client IP.
+func RateLimitByIP() echo.MiddlewareFunc {
+	cfg := config.Cfg.RateLimit
+	limit := ratelimit.PerMinute(cfg.IPRequestsPerMinute, cfg.IPBurst)
+
+	return rateLimit(limit, func(c echo.Context) string {
+		return "ip:" + c.RealIP()
+	})
+}
+
+// RateLimitByUser limits requests per authenticated user. It must run after
+// JWTMiddleware.
+func RateLimitByUser() echo.MiddlewareFunc {
+	cfg := config.Cfg.RateLimit
+	limit := ratelimit.PerMinute(cfg.UserRequestsPerMinute, cfg.UserBurst)
+
+	return rateLimit(limit, func(c echo.Context) string {
+		return "user:" + strconv.FormatUint(uint64(utils.GetUserID(c)), 10)
+	})
+}
+
+func rateLimit(limit ratelimit.Limit, key func(c echo.Context) string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !config.Cfg.RateLimit.Enabled {
+				return next(c)
+			}
+
+			result, err := ratelimit.DefaultStore.Take(c.Request().Context(), "bucket:"+key(c), limit)
+			if err != nil {
+				// Fail open: an unavailable store must not take the API down.
+				slog.ErrorContext(c.Request().Context(), "rate limit store unavailable", "error", err)
+				return next(c)
+			}
+
+			c.Response().Header().Set("X-RateLimit-Limit", strconv.Itoa(limit.Burst))
+			c.Response().Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			if !result.Allowed {
+				return utils.TooManyRequests(c, result.RetryAfter, "too many requests")
+			}
+
+			return next(c)
+		}
+	}
+}
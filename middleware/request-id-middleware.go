@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+	"todo-app/logging"
+
+	"github.com/labstack/echo/v4"
+)
+
+// validRequestID matches the request IDs accepted from clients. They end up
+// in logs and in the 64 character request_id column of the audit log.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestIDMiddleware reuses the X-Request-ID header sent by the client or a
+// proxy if it is well-formed, generates one otherwise, and stores it in the
+// request context so that every log line of the request carries it.
+func RequestIDMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		requestID := c.Request().Header.Get(echo.HeaderXRequestID)
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+
+		c.Response().Header().Set(echo.HeaderXRequestID, requestID)
+		c.SetRequest(c.Request().WithContext(logging.WithRequestID(c.Request().Context(), requestID)))
+
+		return next(c)
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// AuditLog records an action taken by an administrator, or a security event
+// such as the rejection of an infected upload. Entries are kept when the
+// users involved are deleted.
+type AuditLog struct {
+	ID           uint   `json:"id" gorm:"primaryKey;autoIncrement"`
+	ActorID      uint   `json:"actor_id" gorm:"not null;index"`
+	Action       string `json:"action" gorm:"not null;size:64;index"`
+	TargetUserID *uint  `json:"target_user_id" gorm:"index"`
+	// Details is a JSON object describing the action.
+	Details   string    `json:"-"`
+	IP        string    `json:"ip" gorm:"size:64"`
+	RequestID string    `json:"request_id" gorm:"size:64"`
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime;index"`
+}
@@ -0,0 +1,12 @@
+package dto
+
+import "time"
+
+type AdminUserResponse struct {
+	UserResponse
+	DisabledAt *time.Time `json:"disabled_at"`
+	// QuotaBytes and QuotaFiles are null when the user has the default
+	// quota.
+	QuotaBytes *int64 `json:"quota_bytes"`
+	QuotaFiles *int64 `json:"quota_files"`
+}
@@ -0,0 +1,17 @@
+package dto
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type AuditLogResponse struct {
+	ID           uint            `json:"id"`
+	ActorID      uint            `json:"actor_id"`
+	Action       string          `json:"action"`
+	TargetUserID *uint           `json:"target_user_id"`
+	Details      json.RawMessage `json:"details" swaggertype:"object"`
+	IP           string          `json:"ip"`
+	RequestID    string          `json:"request_id"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
@@ -0,0 +1,5 @@
+package dto
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
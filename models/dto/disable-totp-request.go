@@ -0,0 +1,6 @@
+package dto
+
+type DisableTOTPRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
@@ -0,0 +1,6 @@
+package dtoImage
+
+// ImageOrderRequest lists every image of a task in its new order.
+type ImageOrderRequest struct {
+	ImageIDs []uint `json:"image_ids"`
+}
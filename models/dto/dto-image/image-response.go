@@ -3,8 +3,25 @@ package dtoImage
 import "time"
 
 type ImageResponse struct {
-	ID          uint      `json:"id"`
-	Filename    string    `json:"filename"`
-	ContentType string    `json:"content_type"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID          uint   `json:"id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256,omitempty"`
+	// Width and Height are in pixels, omitted when unknown.
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	URL    string `json:"url"`
+	// Position orders the images of a task, lowest first.
+	Position int    `json:"position"`
+	Caption  string `json:"caption"`
+	AltText  string `json:"alt_text"`
+	Cover    bool   `json:"cover"`
+	// Variants maps the name of every configured variant to its URL. Only
+	// images have variants.
+	Variants map[string]string `json:"variants,omitempty"`
+	// ScanStatus is "pending" while the attachment awaits its malware scan
+	// and cannot be downloaded yet, and "clean" afterwards.
+	ScanStatus string    `json:"scan_status"`
+	CreatedAt  time.Time `json:"created_at"`
 }
@@ -0,0 +1,9 @@
+package dtoImage
+
+// ImageUpdateRequest changes the fields that are set. Setting Cover makes the
+// image the cover of its task instead of any other.
+type ImageUpdateRequest struct {
+	Caption *string `json:"caption"`
+	AltText *string `json:"alt_text"`
+	Cover   *bool   `json:"cover"`
+}
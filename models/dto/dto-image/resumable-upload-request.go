@@ -0,0 +1,7 @@
+package dtoImage
+
+type ResumableUploadRequest struct {
+	Filename string `json:"filename"`
+	// Size is the total size of the file in bytes.
+	Size int64 `json:"size"`
+}
@@ -0,0 +1,15 @@
+package dtoImage
+
+import "time"
+
+type ResumableUploadResponse struct {
+	ID       uint   `json:"id"`
+	TaskID   uint   `json:"task_id"`
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	// Offset is the number of bytes received, where the next chunk starts.
+	Offset int64 `json:"offset"`
+	// ChunkSize is the largest chunk accepted in one request.
+	ChunkSize int64     `json:"chunk_size"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -0,0 +1,11 @@
+package dtoImage
+
+// UploadResult is the outcome of one file of a multi-file upload.
+type UploadResult struct {
+	Filename string `json:"filename"`
+	// Status is the HTTP status the file would have got when uploaded on
+	// its own.
+	Status  int            `json:"status"`
+	Message string         `json:"message,omitempty"`
+	Image   *ImageResponse `json:"image,omitempty"`
+}
@@ -0,0 +1,8 @@
+package dto
+
+// MFALoginRequest exchanges the challenge token returned by the login
+// endpoint, together with a TOTP or recovery code, for an access token.
+type MFALoginRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
@@ -0,0 +1,8 @@
+package dto
+
+type PageResponse struct {
+	Items   interface{} `json:"items"`
+	Total   int64       `json:"total"`
+	Page    int         `json:"page"`
+	PerPage int         `json:"per_page"`
+}
@@ -0,0 +1,10 @@
+package dto
+
+import "time"
+
+type PersonalAccessTokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresAt is optional; tokens without it never expire.
+	ExpiresAt *time.Time `json:"expires_at"`
+}
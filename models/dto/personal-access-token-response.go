@@ -0,0 +1,15 @@
+package dto
+
+import "time"
+
+type PersonalAccessTokenResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Hint       string     `json:"hint"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	// Token is only returned when the token is created.
+	Token string `json:"token,omitempty"`
+}
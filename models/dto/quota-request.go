@@ -0,0 +1,8 @@
+package dto
+
+// QuotaRequest sets the quota of a user. Null restores the default and zero
+// means unlimited.
+type QuotaRequest struct {
+	QuotaBytes *int64 `json:"quota_bytes"`
+	QuotaFiles *int64 `json:"quota_files"`
+}
@@ -0,0 +1,6 @@
+package dto
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
@@ -0,0 +1,5 @@
+package dto
+
+type RoleRequest struct {
+	Role string `json:"role"`
+}
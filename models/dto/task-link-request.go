@@ -0,0 +1,8 @@
+package dto
+
+import "time"
+
+type TaskLinkRequest struct {
+	// ExpiresAt is optional; links without it work until revoked.
+	ExpiresAt *time.Time `json:"expires_at"`
+}
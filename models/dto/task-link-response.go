@@ -0,0 +1,13 @@
+package dto
+
+import "time"
+
+type TaskLinkResponse struct {
+	ID        uint       `json:"id"`
+	Hint      string     `json:"hint"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	// Token and URL are only returned when the link is created.
+	Token string `json:"token,omitempty"`
+	URL   string `json:"url,omitempty"`
+}
@@ -6,11 +6,13 @@ import (
 )
 
 type TaskResponse struct {
-	ID          uint           `json:"id"`
-	Title       string         `json:"title"`
-	Description string         `json:"description"`
+	ID          uint                     `json:"id"`
+	Title       string                   `json:"title"`
+	Description string                   `json:"description"`
 	Images      []dtoImage.ImageResponse `json:"images"`
-	Completed   bool           `json:"completed"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
+	// CoverImageID is the image that represents the task, if any.
+	CoverImageID *uint     `json:"cover_image_id"`
+	Completed    bool      `json:"completed"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
 }
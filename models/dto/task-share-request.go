@@ -0,0 +1,7 @@
+package dto
+
+type TaskShareRequest struct {
+	Email string `json:"email"`
+	// Permission is view or edit.
+	Permission string `json:"permission"`
+}
@@ -0,0 +1,12 @@
+package dto
+
+import "time"
+
+type TaskShareResponse struct {
+	ID         uint      `json:"id"`
+	UserID     uint      `json:"user_id"`
+	Username   string    `json:"username"`
+	Email      string    `json:"email"`
+	Permission string    `json:"permission"`
+	CreatedAt  time.Time `json:"created_at"`
+}
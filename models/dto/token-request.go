@@ -0,0 +1,5 @@
+package dto
+
+type TokenRequest struct {
+	Token string `json:"token"`
+}
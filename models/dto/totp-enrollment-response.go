@@ -0,0 +1,8 @@
+package dto
+
+type TOTPEnrollmentResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+	// QRCodePNG is the base64 encoded PNG of the otpauth URI.
+	QRCodePNG string `json:"qr_code_png"`
+}
@@ -0,0 +1,5 @@
+package dto
+
+type TOTPRequest struct {
+	Code string `json:"code"`
+}
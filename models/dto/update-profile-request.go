@@ -0,0 +1,7 @@
+package dto
+
+// UpdateProfileRequest only changes the fields that are present.
+type UpdateProfileRequest struct {
+	Username *string `json:"username"`
+	Email    *string `json:"email"`
+}
@@ -0,0 +1,17 @@
+package dto
+
+type UsageResponse struct {
+	Bytes int64 `json:"bytes"`
+	Files int64 `json:"files"`
+	// QuotaBytes and QuotaFiles are zero when unlimited.
+	QuotaBytes int64               `json:"quota_bytes"`
+	QuotaFiles int64               `json:"quota_files"`
+	Tasks      []TaskUsageResponse `json:"tasks"`
+}
+
+type TaskUsageResponse struct {
+	TaskID uint   `json:"task_id"`
+	Title  string `json:"title"`
+	Bytes  int64  `json:"bytes"`
+	Files  int64  `json:"files"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// ImageBlob holds image content once per user, however many images share it.
+type ImageBlob struct {
+	ID          uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserID      uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_image_blobs_user_sha256"`
+	User        User      `json:"-" gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE"`
+	SHA256      string    `json:"sha256" gorm:"not null;size:64;uniqueIndex:idx_image_blobs_user_sha256"`
+	Size        int64     `json:"size" gorm:"not null"`
+	ContentType string    `json:"content_type" gorm:"not null"`
+	Data        []byte    `json:"-" gorm:"type:bytea;not null"`
+	CreatedAt   time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
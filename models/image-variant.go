@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// ImageVariant is a resized version of an image, generated the first time it
+// is requested.
+type ImageVariant struct {
+	ID          uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	ImageID     uint      `json:"image_id" gorm:"not null;uniqueIndex:idx_image_variants_image_name"`
+	Image       Image     `json:"-" gorm:"foreignKey:ImageID;references:ID;constraint:OnDelete:CASCADE"`
+	Name        string    `json:"name" gorm:"not null;uniqueIndex:idx_image_variants_image_name"`
+	Width       int       `json:"width" gorm:"not null"`
+	Height      int       `json:"height" gorm:"not null"`
+	ContentType string    `json:"content_type" gorm:"not null"`
+	Data        []byte    `json:"-" gorm:"type:bytea;not null"`
+	CreatedAt   time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
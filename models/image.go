@@ -1,12 +1,63 @@
 package models
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
+// Image is an attachment of a task. Despite the name it can hold any of the
+// allowed content types; see IsImage.
 type Image struct {
-	ID          uint      `json:"id" gorm:"primaryKey;autoIncrement"`
-	TaskID      uint      `json:"task_id" gorm:"not null;index;constraint:OnDelete:CASCADE"`
-	Filename    string    `json:"filename" gorm:"not null"`
-	Data        []byte    `json:"data" gorm:"bytea;not null"`
-	ContentType string    `json:"content_type" gorm:"not null"`
-	CreatedAt   time.Time `json:"created_at" gorm:"autoCreateTime"`
+	ID       uint   `json:"id" gorm:"primaryKey;autoIncrement"`
+	TaskID   uint   `json:"task_id" gorm:"not null;index;constraint:OnDelete:CASCADE"`
+	Filename string `json:"filename" gorm:"not null"`
+	// Data is only set for images uploaded before content moved to
+	// ImageBlob.
+	Data        []byte     `json:"data" gorm:"type:bytea"`
+	BlobID      *uint      `json:"blob_id" gorm:"index"`
+	Blob        *ImageBlob `json:"-" gorm:"foreignKey:BlobID;references:ID"`
+	Size        int64      `json:"size"`
+	SHA256      string     `json:"sha256" gorm:"size:64"`
+	ContentType string     `json:"content_type" gorm:"not null"`
+	// Width and Height are zero for images uploaded before they were
+	// recorded.
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// Position orders the images of a task, lowest first.
+	Position int    `json:"position" gorm:"not null;default:0"`
+	Caption  string `json:"caption"`
+	AltText  string `json:"alt_text"`
+	// Cover marks the image that represents its task. At most one image
+	// per task has it.
+	Cover bool `json:"cover" gorm:"not null;default:false"`
+	// Metadata is a JSON object of the EXIF tags removed from the upload,
+	// kept when upload.keep_metadata is set.
+	Metadata string `json:"-"`
+	// UploaderID is the user who uploaded the attachment, nil for
+	// attachments uploaded before it was recorded.
+	UploaderID *uint `json:"uploader_id" gorm:"index"`
+	// ScanStatus is one of the ScanStatus constants. Attachments uploaded
+	// before scanning was introduced are considered clean.
+	ScanStatus string     `json:"scan_status" gorm:"not null;size:16;default:clean;index"`
+	ScannedAt  *time.Time `json:"scanned_at"`
+	// ScanAttempts counts the retries of the scan of a pending attachment,
+	// the last at ScanAttemptedAt, so that attachments that keep failing
+	// are retried after the others.
+	ScanAttempts    int        `json:"-" gorm:"not null;default:0"`
+	ScanAttemptedAt *time.Time `json:"-"`
+	CreatedAt       time.Time  `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// Attachments are quarantined as pending until the malware scanner has
+// checked them. Infected attachments are deleted rather than kept with a
+// status of their own.
+const (
+	ScanStatusPending = "pending"
+	ScanStatusClean   = "clean"
+)
+
+// IsImage reports whether the attachment is a picture, which resized variants
+// and dimensions apply to.
+func (i Image) IsImage() bool {
+	return strings.HasPrefix(i.ContentType, "image/")
 }
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	ScopeTasksRead   = "tasks:read"
+	ScopeTasksWrite  = "tasks:write"
+	ScopeImagesWrite = "images:write"
+)
+
+// Scopes lists every scope a personal access token can be granted.
+var Scopes = []string{ScopeTasksRead, ScopeTasksWrite, ScopeImagesWrite}
+
+// PersonalAccessTokenPrefix starts every personal access token, which tells
+// them apart from session JWTs and makes leaked tokens easy to scan for.
+const PersonalAccessTokenPrefix = "tdp_"
+
+// PersonalAccessToken is a long-lived credential for scripts, limited to its
+// scopes. Only a keyed hash of the token is stored.
+type PersonalAccessToken struct {
+	ID        uint   `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserID    uint   `json:"user_id" gorm:"not null;index"`
+	User      User   `json:"-" gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE"`
+	Name      string `json:"name" gorm:"not null;size:100"`
+	TokenHash string `json:"-" gorm:"not null;uniqueIndex"`
+	// Hint holds the first characters of the token so that users can tell
+	// their tokens apart.
+	Hint string `json:"hint" gorm:"not null;size:16"`
+	// Scopes is space separated.
+	Scopes     string     `json:"-" gorm:"not null"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"autoCreateTime"`
+}
+
+func (t PersonalAccessToken) ScopeList() []string {
+	return strings.Fields(t.Scopes)
+}
+
+func (t PersonalAccessToken) HasScope(scope string) bool {
+	return slices.Contains(t.ScopeList(), scope)
+}
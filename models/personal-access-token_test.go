@@ -0,0 +1,17 @@
+package models
+
+import "testing"
+
+func TestPersonalAccessTokenHasScope(t *testing.T) {
+	token := PersonalAccessToken{Scopes: "images:write tasks:read"}
+
+	if !token.HasScope(ScopeTasksRead) || !token.HasScope(ScopeImagesWrite) {
+		t.Errorf("granted scopes missing from %q", token.Scopes)
+	}
+	if token.HasScope(ScopeTasksWrite) {
+		t.Error("scope that was not granted reported")
+	}
+	if token.HasScope("tasks") {
+		t.Error("prefix of a scope reported")
+	}
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// RecoveryCode is a one-time code that replaces a TOTP code when the
+// authenticator is lost. Only a keyed hash of the code is stored.
+type RecoveryCode struct {
+	ID        uint       `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	User      User       `json:"-" gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE"`
+	CodeHash  string     `json:"-" gorm:"not null;uniqueIndex"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at" gorm:"autoCreateTime"`
+}
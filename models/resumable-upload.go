@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// ResumableUpload is a file sent in chunks, which becomes an image of its
+// task once complete. Unfinished uploads are deleted after ExpiresAt.
+type ResumableUpload struct {
+	ID       uint   `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserID   uint   `json:"user_id" gorm:"not null;index"`
+	TaskID   uint   `json:"task_id" gorm:"not null;index"`
+	Filename string `json:"filename" gorm:"not null"`
+	// Size is the total size announced by the client and Received the
+	// number of bytes stored so far.
+	Size     int64 `json:"size" gorm:"not null"`
+	Received int64 `json:"received" gorm:"not null;default:0"`
+	// Completing is set while a request assembles the upload, so that it is
+	// completed or deleted only once.
+	Completing bool      `json:"completing" gorm:"not null;default:false"`
+	ExpiresAt  time.Time `json:"expires_at" gorm:"not null;index"`
+	CreatedAt  time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// ResumableUploadChunk is a part of a ResumableUpload, starting at Offset.
+type ResumableUploadChunk struct {
+	ID       uint            `json:"id" gorm:"primaryKey;autoIncrement"`
+	UploadID uint            `json:"upload_id" gorm:"not null;uniqueIndex:idx_resumable_upload_chunks_upload_offset"`
+	Upload   ResumableUpload `json:"-" gorm:"foreignKey:UploadID;references:ID;constraint:OnDelete:CASCADE"`
+	// Offset is stored as byte_offset since OFFSET is an SQL keyword.
+	Offset int64  `json:"offset" gorm:"column:byte_offset;not null;uniqueIndex:idx_resumable_upload_chunks_upload_offset"`
+	Data   []byte `json:"-" gorm:"type:bytea;not null"`
+}
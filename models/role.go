@@ -0,0 +1,27 @@
+package models
+
+import "slices"
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Roles lists every role a user can have.
+var Roles = []string{RoleUser, RoleAdmin}
+
+const (
+	PermissionUsersRead    = "users:read"
+	PermissionUsersManage  = "users:manage"
+	PermissionTasksReadAll = "tasks:read_all"
+	PermissionAuditRead    = "audit:read"
+)
+
+var rolePermissions = map[string][]string{
+	RoleAdmin: {PermissionUsersRead, PermissionUsersManage, PermissionTasksReadAll, PermissionAuditRead},
+}
+
+// HasPermission reports whether role grants permission.
+func HasPermission(role string, permission string) bool {
+	return slices.Contains(rolePermissions[role], permission)
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Session is a login. Access tokens carry the session ID in their "sid"
+// claim and stop working once the session is revoked.
+type Session struct {
+	ID        string     `json:"id" gorm:"primaryKey;size:64"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	User      User       `json:"-" gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt time.Time  `json:"created_at" gorm:"autoCreateTime"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// SigningKey is an asymmetric key for access tokens, identified by the "kid"
+// header. A key signs new tokens until it is retired and verifies them until
+// it expires.
+type SigningKey struct {
+	ID        string `json:"kid" gorm:"primaryKey;size:64"`
+	Algorithm string `json:"alg" gorm:"not null;size:16"`
+	// PrivateKey is a PKCS #8 PEM block, encrypted.
+	PrivateKey string     `json:"-" gorm:"not null"`
+	RetiresAt  *time.Time `json:"retires_at"`
+	ExpiresAt  *time.Time `json:"expires_at" gorm:"index"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"autoCreateTime"`
+}
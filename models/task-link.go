@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// TaskLink is a public read-only link to a task. Only a keyed hash of the
+// link token is stored.
+type TaskLink struct {
+	ID        uint       `json:"id" gorm:"primaryKey;autoIncrement"`
+	TaskID    uint       `json:"task_id" gorm:"not null;index"`
+	Task      Task       `json:"-" gorm:"foreignKey:TaskID;references:ID;constraint:OnDelete:CASCADE"`
+	TokenHash string     `json:"-" gorm:"not null;uniqueIndex"`
+	Hint      string     `json:"hint" gorm:"not null;size:16"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at" gorm:"autoCreateTime"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+const (
+	SharePermissionView = "view"
+	SharePermissionEdit = "edit"
+)
+
+// TaskShare gives another user access to a task.
+type TaskShare struct {
+	ID         uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	TaskID     uint      `json:"task_id" gorm:"not null;uniqueIndex:idx_task_shares_task_user"`
+	Task       Task      `json:"-" gorm:"foreignKey:TaskID;references:ID;constraint:OnDelete:CASCADE"`
+	UserID     uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_task_shares_task_user;index"`
+	User       User      `json:"-" gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE"`
+	Permission string    `json:"permission" gorm:"not null;size:16"`
+	CreatedAt  time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
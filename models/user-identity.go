@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// UserIdentity links a user to an account at an external OpenID Connect
+// provider, identified by the provider's subject claim.
+type UserIdentity struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"not null;index"`
+	User      User      `json:"-" gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE"`
+	Provider  string    `json:"provider" gorm:"not null;size:64;uniqueIndex:idx_user_identities_provider_subject"`
+	Subject   string    `json:"subject" gorm:"not null;size:255;uniqueIndex:idx_user_identities_provider_subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
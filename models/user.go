@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 type User struct {
 	ID        uint      `json:"id" gorm:"primaryKey;autoIncrement"`
@@ -10,4 +13,30 @@ type User struct {
 	Tasks     []Task    `json:"tasks" gorm:"foreignKey:UserID"`
 	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
 	UpdatedAt time.Time `json:"updated_at" gorm:"autoUpdateTIme"`
+
+	Role       string     `json:"-" gorm:"not null;size:16;default:user"`
+	DisabledAt *time.Time `json:"-"`
+
+	// QuotaBytes and QuotaFiles override upload.quota_bytes and
+	// upload.quota_files when set by an administrator. Zero means
+	// unlimited.
+	QuotaBytes *int64 `json:"-"`
+	QuotaFiles *int64 `json:"-"`
+
+	EmailVerifiedAt     *time.Time `json:"-"`
+	DeletionScheduledAt *time.Time `json:"-"`
+
+	// TOTPSecret is encrypted. It is set during enrollment and only
+	// enforced once TOTPEnabledAt is set.
+	TOTPSecret    string     `json:"-"`
+	TOTPEnabledAt *time.Time `json:"-"`
+	// TOTPLastStep is the time step of the last accepted code, so that a
+	// code cannot be replayed.
+	TOTPLastStep int64 `json:"-"`
+}
+
+// NormalizeEmail returns the form email addresses are stored and looked up
+// in, so that addresses differing only in case belong to one account.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
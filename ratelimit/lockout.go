@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Lockout locks a key out after repeated failures. Every failure past
+// MaxFailures doubles the lockout, up to MaxLockout.
+type Lockout struct {
+	Store         Store
+	MaxFailures   int
+	FailureWindow time.Duration
+	BaseLockout   time.Duration
+	MaxLockout    time.Duration
+}
+
+// Check returns how long any of the keys remains locked.
+func (l Lockout) Check(ctx context.Context, keys ...string) (time.Duration, error) {
+	var longest time.Duration
+	for _, key := range keys {
+		remaining, err := l.Store.LockedFor(ctx, "lockout:"+key)
+		if err != nil {
+			return 0, err
+		}
+		if remaining > longest {
+			longest = remaining
+		}
+	}
+	return longest, nil
+}
+
+// Fail records a failure for each key and returns the resulting lockout, if
+// any.
+func (l Lockout) Fail(ctx context.Context, keys ...string) (time.Duration, error) {
+	var longest time.Duration
+	for _, key := range keys {
+		failures, err := l.Store.Incr(ctx, "failures:"+key, l.FailureWindow)
+		if err != nil {
+			return 0, err
+		}
+		if failures < int64(l.MaxFailures) {
+			continue
+		}
+
+		lockout := l.BaseLockout
+		for i := int64(l.MaxFailures); i < failures && lockout < l.MaxLockout; i++ {
+			lockout *= 2
+		}
+		if lockout > l.MaxLockout {
+			lockout = l.MaxLockout
+		}
+		if err := l.Store.Lock(ctx, "lockout:"+key, lockout); err != nil {
+			return 0, err
+		}
+		if lockout > longest {
+			longest = lockout
+		}
+	}
+	return longest, nil
+}
+
+// Reset clears the failures of a key after a successful attempt.
+func (l Lockout) Reset(ctx context.Context, key string) error {
+	return l.Store.Delete(ctx, "failures:"+key, "lockout:"+key)
+}
@@ -0,0 +1,138 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	expires time.Time
+}
+
+type counter struct {
+	value   int64
+	expires time.Time
+}
+
+// MemoryStore keeps rate limit state in process memory.
+type MemoryStore struct {
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	counters map[string]*counter
+	locks    map[string]time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{
+		buckets:  map[string]*bucket{},
+		counters: map[string]*counter{},
+		locks:    map[string]time.Time{},
+	}
+	go s.cleanup(time.Minute)
+	return s
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		s.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*limit.Rate)
+	b.updated = now
+	b.expires = now.Add(time.Duration(float64(limit.Burst) / limit.Rate * float64(time.Second)))
+
+	if b.tokens < 1 {
+		wait := (1 - b.tokens) / limit.Rate
+		return Result{RetryAfter: time.Duration(wait * float64(time.Second))}, nil
+	}
+
+	b.tokens--
+	return Result{Allowed: true, Remaining: int(b.tokens)}, nil
+}
+
+func (s *MemoryStore) Incr(_ context.Context, key string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	c, ok := s.counters[key]
+	if !ok || now.After(c.expires) {
+		c = &counter{expires: now.Add(ttl)}
+		s.counters[key] = c
+	}
+	c.value++
+
+	return c.value, nil
+}
+
+func (s *MemoryStore) Lock(_ context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.locks[key] = time.Now().Add(ttl)
+	return nil
+}
+
+func (s *MemoryStore) LockedFor(_ context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	until, ok := s.locks[key]
+	if !ok {
+		return 0, nil
+	}
+	remaining := time.Until(until)
+	if remaining <= 0 {
+		delete(s.locks, key)
+		return 0, nil
+	}
+
+	return remaining, nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		delete(s.buckets, key)
+		delete(s.counters, key)
+		delete(s.locks, key)
+	}
+	return nil
+}
+
+// cleanup drops full buckets and expired counters and locks so that memory
+// does not grow with every client ever seen.
+func (s *MemoryStore) cleanup(interval time.Duration) {
+	for range time.Tick(interval) {
+		s.mu.Lock()
+		now := time.Now()
+		for key, b := range s.buckets {
+			if now.After(b.expires) {
+				delete(s.buckets, key)
+			}
+		}
+		for key, c := range s.counters {
+			if now.After(c.expires) {
+				delete(s.counters, key)
+			}
+		}
+		for key, until := range s.locks {
+			if now.After(until) {
+				delete(s.locks, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limit describes a token bucket: Rate tokens are added per second up to a
+// maximum of Burst.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// PerMinute returns a limit refilling n tokens per minute.
+func PerMinute(n int, burst int) Limit {
+	return Limit{Rate: float64(n) / 60, Burst: burst}
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// Store keeps rate limit state. MemoryStore works for a single instance,
+// RedisStore shares state between instances.
+type Store interface {
+	// Take removes one token from the bucket identified by key.
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+	// Incr increments a counter, starting its ttl on the first increment.
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// Lock marks key as locked for ttl.
+	Lock(ctx context.Context, key string, ttl time.Duration) error
+	// LockedFor returns how long key stays locked, or zero.
+	LockedFor(ctx context.Context, key string) (time.Duration, error)
+	// Delete removes counters and locks.
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// DefaultStore is the store used by the rate limit middleware and the login
+// lockout. It is set at startup.
+var DefaultStore Store
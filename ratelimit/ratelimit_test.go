@@ -0,0 +1,144 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreTakeAllowsBurstThenRefuses(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	limit := PerMinute(60, 3)
+
+	for i := 0; i < 3; i++ {
+		result, err := store.Take(ctx, "client", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed {
+			t.Fatalf("request %d refused within the burst", i+1)
+		}
+		if want := 2 - i; result.Remaining != want {
+			t.Errorf("request %d: remaining = %d, want %d", i+1, result.Remaining, want)
+		}
+	}
+
+	result, err := store.Take(ctx, "client", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed {
+		t.Fatal("request allowed past the burst")
+	}
+	if result.RetryAfter <= 0 || result.RetryAfter > time.Second {
+		t.Errorf("retry after = %v, want up to one token interval of 1s", result.RetryAfter)
+	}
+
+	result, err = store.Take(ctx, "other", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Allowed {
+		t.Error("bucket of another key was drained")
+	}
+}
+
+func TestMemoryStoreTakeRefills(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	limit := Limit{Rate: 100, Burst: 1}
+
+	if result, _ := store.Take(ctx, "client", limit); !result.Allowed {
+		t.Fatal("first request refused")
+	}
+	if result, _ := store.Take(ctx, "client", limit); result.Allowed {
+		t.Fatal("second request allowed before the refill")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if result, _ := store.Take(ctx, "client", limit); !result.Allowed {
+		t.Error("request refused after the bucket refilled")
+	}
+}
+
+func testLockout() Lockout {
+	return Lockout{
+		Store:         NewMemoryStore(),
+		MaxFailures:   3,
+		FailureWindow: time.Minute,
+		BaseLockout:   time.Minute,
+		MaxLockout:    5 * time.Minute,
+	}
+}
+
+func TestLockoutLocksAfterMaxFailures(t *testing.T) {
+	ctx := context.Background()
+	lockout := testLockout()
+
+	for i := 0; i < 2; i++ {
+		locked, err := lockout.Fail(ctx, "user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if locked != 0 {
+			t.Fatalf("failure %d locked for %v before reaching the maximum", i+1, locked)
+		}
+	}
+	if locked, _ := lockout.Check(ctx, "user"); locked != 0 {
+		t.Fatalf("checked locked for %v before reaching the maximum", locked)
+	}
+
+	locked, err := lockout.Fail(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locked != time.Minute {
+		t.Errorf("locked for %v, want %v", locked, time.Minute)
+	}
+	remaining, err := lockout.Check(ctx, "other", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining <= 0 || remaining > time.Minute {
+		t.Errorf("check = %v, want up to %v", remaining, time.Minute)
+	}
+	if remaining, _ := lockout.Check(ctx, "other"); remaining != 0 {
+		t.Errorf("unrelated key locked for %v", remaining)
+	}
+}
+
+func TestLockoutDoublesUpToMax(t *testing.T) {
+	ctx := context.Background()
+	lockout := testLockout()
+
+	want := []time.Duration{0, 0, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for i, w := range want {
+		locked, err := lockout.Fail(ctx, "user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if locked != w {
+			t.Errorf("failure %d: locked for %v, want %v", i+1, locked, w)
+		}
+	}
+}
+
+func TestLockoutReset(t *testing.T) {
+	ctx := context.Background()
+	lockout := testLockout()
+
+	for i := 0; i < 3; i++ {
+		if _, err := lockout.Fail(ctx, "user"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := lockout.Reset(ctx, "user"); err != nil {
+		t.Fatal(err)
+	}
+	if remaining, _ := lockout.Check(ctx, "user"); remaining != 0 {
+		t.Errorf("locked for %v after reset", remaining)
+	}
+	if locked, _ := lockout.Fail(ctx, "user"); locked != 0 {
+		t.Errorf("first failure after reset locked for %v", locked)
+	}
+}
@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// takeScript refills and takes from a token bucket atomically. The bucket is
+// a hash of the current token count and the time of the last update.
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(state[1]) or burst
+local updated = tonumber(state[2]) or now
+
+tokens = math.min(burst, tokens + (now - updated) / 1000 * rate)
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate * 1000)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate * 1000))
+
+return {allowed, math.floor(tokens), retry}
+`)
+
+var incrScript = redis.NewScript(`
+local value = redis.call("INCR", KEYS[1])
+if value == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return value
+`)
+
+// RedisStore keeps rate limit state in Redis or any server speaking its
+// protocol, so that limits are shared between instances.
+type RedisStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+func NewRedisStore(client redis.UniversalClient, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+func (s *RedisStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := time.Now().UnixMilli()
+	values, err := takeScript.Run(ctx, s.client, []string{s.prefix + key},
+		strconv.FormatFloat(limit.Rate, 'f', -1, 64), limit.Burst, now).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	if len(values) != 3 {
+		return Result{}, errors.New("ratelimit: unexpected reply from redis")
+	}
+
+	return Result{
+		Allowed:    values[0] == 1,
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+	}, nil
+}
+
+func (s *RedisStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return incrScript.Run(ctx, s.client, []string{s.prefix + key}, ttl.Milliseconds()).Int64()
+}
+
+func (s *RedisStore) Lock(ctx context.Context, key string, ttl time.Duration) error {
+	return s.client.Set(ctx, s.prefix+"lock:"+key, 1, ttl).Err()
+}
+
+func (s *RedisStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := s.client.PTTL(ctx, s.prefix+"lock:"+key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+func (s *RedisStore) Delete(ctx context.Context, keys ...string) error {
+	prefixed := make([]string, 0, len(keys)*2)
+	for _, key := range keys {
+		prefixed = append(prefixed, s.prefix+key, s.prefix+"lock:"+key)
+	}
+	return s.client.Del(ctx, prefixed...).Err()
+}
+
+// Ping checks that the Redis server is reachable.
+func (s *RedisStore) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
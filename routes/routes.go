@@ -1,34 +1,104 @@
 package routes
 
 import (
+	"todo-app/config"
 	"todo-app/controllers"
 	_ "todo-app/docs"
 	"todo-app/middleware"
+	"todo-app/models"
 
 	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	echoSwagger "github.com/swaggo/echo-swagger"
 )
 
 func SetupRoutes(e *echo.Echo) {
 	e.GET("/swagger/*", echoSwagger.WrapHandler)
+	e.GET("/healthz", controllers.Healthz)
+	e.GET("/readyz", controllers.Readyz)
+	e.GET("/.well-known/jwks.json", controllers.JWKS)
+	if config.Cfg.Metrics.Enabled {
+		e.GET(config.Cfg.Metrics.Path, echo.WrapHandler(promhttp.Handler()))
+	}
 
-	apiGroup := e.Group("/api")
+	apiGroup := e.Group("/api", middleware.RateLimitByIP())
 
 	authGroup := apiGroup.Group("/auth")
 	authGroup.POST("/login", controllers.Login)
+	authGroup.POST("/login/2fa", controllers.LoginMFA)
 	authGroup.POST("/register", controllers.Register)
-	authGroup.GET("/me", controllers.GetMe, middleware.JWTMiddleware())
+	authGroup.POST("/verify-email", controllers.VerifyEmail)
+	authGroup.POST("/resend-verification", controllers.ResendVerification)
+	authGroup.POST("/forgot-password", controllers.ForgotPassword)
+	authGroup.POST("/reset-password", controllers.ResetPassword)
+	authGroup.GET("/oidc/:provider/login", controllers.OIDCLogin)
+	authGroup.GET("/oidc/:provider/callback", controllers.OIDCCallback)
 
-	taskGroup := apiGroup.Group("/tasks", middleware.JWTMiddleware())
-	taskGroup.POST("", controllers.CreateTask)
-	taskGroup.GET("", controllers.GetTasks)
-	taskGroup.GET("/:id", controllers.GetTaskById)
-	taskGroup.PATCH("/:id", controllers.UpdateTaskById)
-	taskGroup.DELETE("/:id", controllers.DeleteTaskById)
+	meGroup := authGroup.Group("/me", middleware.JWTMiddleware(), middleware.RateLimitByUser())
+	meGroup.GET("", controllers.GetMe)
+	meGroup.PATCH("", controllers.UpdateMe)
+	meGroup.DELETE("", controllers.DeleteMe)
+	meGroup.POST("/password", controllers.ChangePassword)
+	meGroup.GET("/usage", controllers.GetUsage)
+	meGroup.POST("/2fa/enroll", controllers.EnrollTOTP)
+	meGroup.POST("/2fa/verify", controllers.VerifyTOTP)
+	meGroup.POST("/2fa/recovery-codes", controllers.RegenerateRecoveryCodes)
+	meGroup.DELETE("/2fa", controllers.DisableTOTP)
 
-	taskGroup.POST("/:task_id/images", controllers.UploadImage, middleware.ImageUploadMiddleware)
+	// Personal access tokens cannot manage tokens or the account.
+	tokenGroup := authGroup.Group("/tokens", middleware.JWTMiddleware(), middleware.RateLimitByUser())
+	tokenGroup.GET("", controllers.GetPersonalAccessTokens)
+	tokenGroup.POST("", controllers.CreatePersonalAccessToken)
+	tokenGroup.DELETE("/:id", controllers.DeletePersonalAccessToken)
+
+	read := middleware.RequireScope(models.ScopeTasksRead)
+	write := middleware.RequireScope(models.ScopeTasksWrite)
+
+	taskGroup := apiGroup.Group("/tasks", middleware.AuthMiddleware(), middleware.RateLimitByUser())
+	taskGroup.POST("", controllers.CreateTask, write)
+	taskGroup.GET("", controllers.GetTasks, read)
+	taskGroup.GET("/shared", controllers.GetSharedTasks, read)
+	taskGroup.GET("/export.zip", controllers.ExportTasks, read)
+	taskGroup.GET("/:id", controllers.GetTaskById, read)
+	taskGroup.PATCH("/:id", controllers.UpdateTaskById, write)
+	taskGroup.DELETE("/:id", controllers.DeleteTaskById, write)
+	taskGroup.GET("/:id/export.zip", controllers.ExportTask, read)
+	taskGroup.GET("/:id/shares", controllers.GetTaskShares, read)
+	taskGroup.POST("/:id/shares", controllers.ShareTask, write)
+	taskGroup.DELETE("/:id/shares/:share_id", controllers.DeleteTaskShare, write)
+	taskGroup.GET("/:id/links", controllers.GetTaskLinks, read)
+	taskGroup.POST("/:id/links", controllers.CreateTaskLink, write)
+	taskGroup.DELETE("/:id/links/:link_id", controllers.DeleteTaskLink, write)
+
+	taskGroup.GET("/:task_id/images", controllers.GetTaskImages, read)
+	taskGroup.POST("/:task_id/images", controllers.UploadImage, middleware.RequireScope(models.ScopeImagesWrite), middleware.ImageUploadMiddleware)
+	taskGroup.POST("/:task_id/images/batch", controllers.UploadImages, middleware.RequireScope(models.ScopeImagesWrite), middleware.BatchUploadMiddleware)
+	taskGroup.PUT("/:task_id/images/order", controllers.ReorderTaskImages, middleware.RequireScope(models.ScopeImagesWrite))
+	taskGroup.POST("/:task_id/uploads", controllers.CreateResumableUpload, middleware.RequireScope(models.ScopeImagesWrite))
 	
-	imageGroup := apiGroup.Group("/images")
-	imageGroup.GET("/:id", controllers.GetImageByID)
-	imageGroup.DELETE("/:id", controllers.DeleteImageByID)
+	adminGroup := apiGroup.Group("/admin", middleware.JWTMiddleware(), middleware.RateLimitByUser())
+	adminGroup.GET("/users", controllers.AdminGetUsers, middleware.RequirePermission(models.PermissionUsersRead))
+	adminGroup.GET("/users/:id", controllers.AdminGetUser, middleware.RequirePermission(models.PermissionUsersRead))
+	adminGroup.GET("/users/:id/tasks", controllers.AdminGetUserTasks, middleware.RequirePermission(models.PermissionTasksReadAll))
+	adminGroup.POST("/users/:id/disable", controllers.AdminDisableUser, middleware.RequirePermission(models.PermissionUsersManage))
+	adminGroup.POST("/users/:id/enable", controllers.AdminEnableUser, middleware.RequirePermission(models.PermissionUsersManage))
+	adminGroup.PUT("/users/:id/role", controllers.AdminUpdateUserRole, middleware.RequirePermission(models.PermissionUsersManage))
+	adminGroup.PUT("/users/:id/quota", controllers.AdminUpdateUserQuota, middleware.RequirePermission(models.PermissionUsersManage))
+	adminGroup.POST("/users/:id/password-reset", controllers.AdminForcePasswordReset, middleware.RequirePermission(models.PermissionUsersManage))
+	adminGroup.GET("/audit-logs", controllers.AdminGetAuditLogs, middleware.RequirePermission(models.PermissionAuditRead))
+
+	imageGroup := apiGroup.Group("/images", middleware.AuthMiddleware(), middleware.RateLimitByUser())
+	imageGroup.GET("/:id", controllers.GetImageByID, read)
+	imageGroup.PATCH("/:id", controllers.UpdateImageByID, middleware.RequireScope(models.ScopeImagesWrite))
+	imageGroup.DELETE("/:id", controllers.DeleteImageByID, middleware.RequireScope(models.ScopeImagesWrite))
+
+	uploadGroup := apiGroup.Group("/uploads", middleware.AuthMiddleware(), middleware.RateLimitByUser(), middleware.RequireScope(models.ScopeImagesWrite))
+	uploadGroup.GET("/:id", controllers.GetResumableUpload)
+	uploadGroup.PATCH("/:id", controllers.AppendResumableUpload)
+	uploadGroup.POST("/:id/complete", controllers.CompleteResumableUpload)
+	uploadGroup.DELETE("/:id", controllers.DeleteResumableUpload)
+
+	publicGroup := apiGroup.Group("/public")
+	publicGroup.GET("/tasks/:token", controllers.GetPublicTask)
+	publicGroup.GET("/tasks/:token/images/:image_id", controllers.GetPublicTaskImage)
 }
@@ -0,0 +1,107 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamavChunkSize is the size of the chunks a file is streamed to clamd in.
+const clamavChunkSize = 64 * 1024
+
+// ClamAV scans files with clamd, streaming them over its socket with the
+// INSTREAM command.
+type ClamAV struct {
+	// Network is "unix" or "tcp".
+	Network string
+	Address string
+	Timeout time.Duration
+}
+
+func (s ClamAV) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	reply, err := s.command(ctx, "zINSTREAM\x00", func(conn net.Conn) error {
+		buf := make([]byte, 4+clamavChunkSize)
+		for {
+			n, err := r.Read(buf[4:])
+			if n > 0 {
+				binary.BigEndian.PutUint32(buf, uint32(n))
+				if _, err := conn.Write(buf[:4+n]); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		// A zero length chunk ends the stream.
+		_, err := conn.Write([]byte{0, 0, 0, 0})
+		return err
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	// Replies look like "stream: OK" or "stream: Eicar-Signature FOUND".
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(reply, " FOUND")}, nil
+	default:
+		return Result{}, fmt.Errorf("clamav: %s", reply)
+	}
+}
+
+// Ping checks that clamd is reachable.
+func (s ClamAV) Ping(ctx context.Context) error {
+	reply, err := s.command(ctx, "zPING\x00", nil)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("clamav: unexpected reply to PING: %s", reply)
+	}
+	return nil
+}
+
+// command sends cmd, then lets send write its payload, and returns the
+// reply of clamd.
+func (s ClamAV) command(ctx context.Context, cmd string, send func(net.Conn) error) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, s.Network, s.Address)
+	if err != nil {
+		return "", fmt.Errorf("clamav: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := io.WriteString(conn, cmd); err != nil {
+		return "", fmt.Errorf("clamav: %w", err)
+	}
+	if send != nil {
+		if err := send(conn); err != nil {
+			return "", fmt.Errorf("clamav: %w", err)
+		}
+	}
+
+	// Replies to z-prefixed commands end with a NUL byte.
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil {
+		return "", fmt.Errorf("clamav: %w", err)
+	}
+	return strings.TrimSpace(strings.TrimSuffix(reply, "\x00")), nil
+}
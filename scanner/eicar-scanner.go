@@ -0,0 +1,25 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"io"
+)
+
+// eicarSignature is the start of the EICAR anti-virus test file.
+var eicarSignature = []byte(`X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!`)
+
+// EICAR flags the EICAR test file and accepts everything else, so that the
+// handling of infected files can be tried without a virus scanner.
+type EICAR struct{}
+
+func (EICAR) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Result{}, err
+	}
+	if bytes.Contains(data, eicarSignature) {
+		return Result{Infected: true, Signature: "Eicar-Test-Signature"}, nil
+	}
+	return Result{}, nil
+}
@@ -0,0 +1,28 @@
+package scanner
+
+import (
+	"context"
+	"io"
+)
+
+// Result is the verdict on a scanned file.
+type Result struct {
+	Infected bool
+	// Signature names the malware found in an infected file.
+	Signature string
+}
+
+// Scanner checks files for malware.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+}
+
+// Default is the scanner used by the application. It is set at startup.
+var Default Scanner = Noop{}
+
+// Noop accepts every file. It is used when scanning is disabled.
+type Noop struct{}
+
+func (Noop) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	return Result{}, nil
+}
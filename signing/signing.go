@@ -0,0 +1,336 @@
+package signing
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+	"todo-app/config"
+	"todo-app/models"
+	"todo-app/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	// cacheTTL bounds how long an instance keeps signing with a key after
+	// another instance has rotated it.
+	cacheTTL = time.Minute
+	// minReload keeps tokens with unknown key IDs from hitting the database
+	// on every request.
+	minReload = 10 * time.Second
+	// rotateAhead starts the next key before the current one retires, so
+	// that the hourly rotation job never leaves a gap.
+	rotateAhead = 2 * time.Hour
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+type key struct {
+	id        string
+	method    jwt.SigningMethod
+	private   crypto.Signer
+	retiresAt time.Time
+	createdAt time.Time
+}
+
+// JWK is a public key in JSON Web Key format.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is the document served at /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+var (
+	mu       sync.Mutex
+	keys     map[string]*key
+	loadedAt time.Time
+)
+
+func symmetric() bool {
+	return config.Cfg.Auth.JWTAlgorithm == jwt.SigningMethodHS256.Alg()
+}
+
+// Sign returns the signed token for claims, using the newest key of the
+// configured algorithm.
+func Sign(ctx context.Context, claims jwt.Claims) (string, error) {
+	if symmetric() {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.Cfg.Auth.JWTSecret))
+	}
+
+	k, err := currentKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(k.method, claims)
+	token.Header["kid"] = k.id
+	return token.SignedString(k.private)
+}
+
+// Keyfunc returns the key to verify token with, looked up by its "kid"
+// header.
+func Keyfunc(token *jwt.Token) (interface{}, error) {
+	if symmetric() {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		return []byte(config.Cfg.Auth.JWTSecret), nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	k, err := lookup(context.Background(), kid)
+	if err != nil {
+		return nil, err
+	}
+	if token.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+
+	return k.private.Public(), nil
+}
+
+// PublicKeys returns every key that can still verify tokens.
+func PublicKeys(ctx context.Context) (JWKS, error) {
+	set := JWKS{Keys: []JWK{}}
+	if symmetric() {
+		return set, nil
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if err := loadIfStale(ctx, cacheTTL); err != nil {
+		return set, err
+	}
+
+	for _, k := range keys {
+		jwk := JWK{Kid: k.id, Use: "sig", Alg: k.method.Alg()}
+		switch public := k.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set, nil
+}
+
+// Rotate creates a key for the configured algorithm when there is none or
+// the current one is about to retire, and deletes keys whose tokens have all
+// expired. It runs at startup and then periodically.
+func Rotate(ctx context.Context) error {
+	if symmetric() {
+		return nil
+	}
+
+	auth := config.Cfg.Auth
+	now := time.Now()
+
+	err := config.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at < ?", now).Delete(&models.SigningKey{}).Error; err != nil {
+			return err
+		}
+
+		var current int64
+		err := tx.Model(&models.SigningKey{}).
+			Where("algorithm = ? AND retires_at > ?", auth.JWTAlgorithm, now.Add(rotateAhead)).
+			Count(&current).Error
+		if err != nil || current > 0 {
+			return err
+		}
+
+		// Tokens signed by the keys being replaced expire at the latest one
+		// token lifetime after the last instance stops using them.
+		expiresAt := now.Add(auth.AccessTokenTTL + cacheTTL)
+		err = tx.Model(&models.SigningKey{}).
+			Where("retires_at > ?", now).
+			Updates(map[string]interface{}{"retires_at": now, "expires_at": expiresAt}).Error
+		if err != nil {
+			return err
+		}
+
+		signingKey, err := generate(auth.JWTAlgorithm)
+		if err != nil {
+			return err
+		}
+		retiresAt := now.Add(auth.KeyRotation)
+		signingKey.RetiresAt = &retiresAt
+		expiresAt = retiresAt.Add(auth.AccessTokenTTL + cacheTTL)
+		signingKey.ExpiresAt = &expiresAt
+
+		return tx.Create(&signingKey).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	return load(ctx)
+}
+
+func currentKey(ctx context.Context) (*key, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if err := loadIfStale(ctx, cacheTTL); err != nil {
+		return nil, err
+	}
+
+	var newest *key
+	now := time.Now()
+	for _, k := range keys {
+		if k.method.Alg() != config.Cfg.Auth.JWTAlgorithm || !k.retiresAt.After(now) {
+			continue
+		}
+		if newest == nil || k.createdAt.After(newest.createdAt) {
+			newest = k
+		}
+	}
+	if newest == nil {
+		return nil, errors.New("no active signing key")
+	}
+
+	return newest, nil
+}
+
+func lookup(ctx context.Context, kid string) (*key, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if err := loadIfStale(ctx, cacheTTL); err != nil {
+		return nil, err
+	}
+
+	if k, ok := keys[kid]; ok {
+		return k, nil
+	}
+
+	// The key may have just been created by another instance.
+	if err := loadIfStale(ctx, minReload); err != nil {
+		return nil, err
+	}
+	if k, ok := keys[kid]; ok {
+		return k, nil
+	}
+
+	return nil, ErrUnknownKey
+}
+
+func loadIfStale(ctx context.Context, maxAge time.Duration) error {
+	if keys != nil && time.Since(loadedAt) < maxAge {
+		return nil
+	}
+	return load(ctx)
+}
+
+// load replaces the cached keys. The caller must hold mu.
+func load(ctx context.Context) error {
+	var rows []models.SigningKey
+	err := config.DB.WithContext(ctx).
+		Where("expires_at > ?", time.Now()).
+		Find(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	loaded := make(map[string]*key, len(rows))
+	for _, row := range rows {
+		k, err := parse(row)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", row.ID, err)
+		}
+		loaded[row.ID] = k
+	}
+
+	keys = loaded
+	loadedAt = time.Now()
+	return nil
+}
+
+func generate(algorithm string) (models.SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch algorithm {
+	case jwt.SigningMethodRS256.Alg():
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwt.SigningMethodEdDSA.Alg():
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		err = fmt.Errorf("unsupported algorithm %s", algorithm)
+	}
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+	encrypted, err := utils.EncryptSecret(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})))
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+
+	id, err := utils.NewToken()
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+
+	return models.SigningKey{
+		ID:         id[:16],
+		Algorithm:  algorithm,
+		PrivateKey: encrypted,
+	}, nil
+}
+
+func parse(row models.SigningKey) (*key, error) {
+	decrypted, err := utils.DecryptSecret(row.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode([]byte(decrypted))
+	if block == nil {
+		return nil, errors.New("invalid PEM block")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	private, ok := parsed.(crypto.Signer)
+	method := jwt.GetSigningMethod(row.Algorithm)
+	if !ok || method == nil {
+		return nil, fmt.Errorf("unsupported algorithm %s", row.Algorithm)
+	}
+
+	k := &key{id: row.ID, method: method, private: private, createdAt: row.CreatedAt}
+	if row.RetiresAt != nil {
+		k.retiresAt = *row.RetiresAt
+	}
+	return k, nil
+}
@@ -0,0 +1,399 @@
+package signing
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"todo-app/config"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestMain(m *testing.M) {
+	config.Cfg = config.Default()
+	config.Cfg.Auth.JWTSecret = "test-jwt-secret"
+	config.Cfg.Auth.TokenSecret = "test-token-secret"
+	os.Exit(m.Run())
+}
+
+// statement is a statement run against recordingDB.
+type statement struct {
+	query string
+	args  []driver.Value
+}
+
+// recordingDB is a database/sql connector that records statements, answers
+// counts with count and other queries with no rows.
+type recordingDB struct {
+	count      int64
+	statements []statement
+}
+
+func (r *recordingDB) Connect(context.Context) (driver.Conn, error) { return recordingConn{r}, nil }
+func (r *recordingDB) Driver() driver.Driver                        { return recordingDriver{r} }
+
+// ran returns the statements starting with prefix.
+func (r *recordingDB) ran(prefix string) []statement {
+	var found []statement
+	for _, s := range r.statements {
+		if strings.HasPrefix(s.query, prefix) {
+			found = append(found, s)
+		}
+	}
+	return found
+}
+
+type recordingDriver struct{ db *recordingDB }
+
+func (d recordingDriver) Open(string) (driver.Conn, error) { return recordingConn{d.db}, nil }
+
+type recordingConn struct{ db *recordingDB }
+
+func (c recordingConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c recordingConn) Close() error                        { return nil }
+func (c recordingConn) Begin() (driver.Tx, error)           { return recordingTx{}, nil }
+
+func (c recordingConn) record(query string, named []driver.NamedValue) {
+	args := make([]driver.Value, len(named))
+	for i, arg := range named {
+		args[i] = arg.Value
+	}
+	c.db.statements = append(c.db.statements, statement{query, args})
+}
+
+func (c recordingConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.record(query, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (c recordingConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.record(query, args)
+	if strings.HasPrefix(query, "SELECT count(*)") {
+		return &countRows{count: c.db.count}, nil
+	}
+	return &countRows{done: true}, nil
+}
+
+type recordingTx struct{}
+
+func (recordingTx) Commit() error   { return nil }
+func (recordingTx) Rollback() error { return nil }
+
+// countRows answers a count query, or no rows when done.
+type countRows struct {
+	count int64
+	done  bool
+}
+
+func (r *countRows) Columns() []string { return []string{"count"} }
+func (r *countRows) Close() error      { return nil }
+
+func (r *countRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	dest[0], r.done = r.count, true
+	return nil
+}
+
+// useDB points config.DB at a recording database for the test.
+func useDB(t *testing.T, count int64) *recordingDB {
+	t.Helper()
+	recorder := &recordingDB{count: count}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(recorder)}), &gorm.Config{
+		DisableAutomaticPing: true,
+		Logger:               logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := config.DB
+	config.DB = db
+	t.Cleanup(func() { config.DB = previous })
+	return recorder
+}
+
+// useAlgorithm configures algorithm for the test.
+func useAlgorithm(t *testing.T, algorithm string) {
+	previous := config.Cfg.Auth.JWTAlgorithm
+	config.Cfg.Auth.JWTAlgorithm = algorithm
+	t.Cleanup(func() { config.Cfg.Auth.JWTAlgorithm = previous })
+}
+
+// useKeys replaces the cached keys with ks, loaded age ago.
+func useKeys(t *testing.T, age time.Duration, ks ...*key) {
+	mu.Lock()
+	defer mu.Unlock()
+	keys = make(map[string]*key, len(ks))
+	for _, k := range ks {
+		keys[k.id] = k
+	}
+	loadedAt = time.Now().Add(-age)
+	t.Cleanup(func() { keys, loadedAt = nil, time.Time{} })
+}
+
+// newKey returns a key of algorithm created and retiring at the given times.
+func newKey(t *testing.T, algorithm string, createdAt, retiresAt time.Time) *key {
+	t.Helper()
+	row, err := generate(algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row.CreatedAt, row.RetiresAt = createdAt, &retiresAt
+	k, err := parse(row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestKeyfunc(t *testing.T) {
+	now := time.Now()
+	ed := newKey(t, "EdDSA", now, now.Add(time.Hour))
+	rs := newKey(t, "RS256", now, now.Add(time.Hour))
+	claims := jwt.MapClaims{"sub": "1"}
+
+	rsPublic, err := x509.MarshalPKIXPublicKey(rs.private.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := func(method jwt.SigningMethod, kid string, secret interface{}) string {
+		t.Helper()
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		s, err := token.SignedString(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name      string
+		algorithm string
+		token     string
+		valid     bool
+	}{
+		{"EdDSA", "EdDSA", signed(jwt.SigningMethodEdDSA, ed.id, ed.private), true},
+		{"RS256", "RS256", signed(jwt.SigningMethodRS256, rs.id, rs.private), true},
+		{"HS256", "HS256", signed(jwt.SigningMethodHS256, "", []byte("test-jwt-secret")), true},
+		// The public key is published, so accepting it as an HMAC secret
+		// would let anyone sign tokens.
+		{"HS256 with the public key", "EdDSA", signed(jwt.SigningMethodHS256, ed.id, []byte(ed.private.Public().(ed25519.PublicKey))), false},
+		{"HS256 with the RSA public key", "RS256", signed(jwt.SigningMethodHS256, rs.id, rsPublic), false},
+		{"HS256 with the secret", "EdDSA", signed(jwt.SigningMethodHS256, ed.id, []byte("test-jwt-secret")), false},
+		{"kid of another algorithm", "RS256", signed(jwt.SigningMethodEdDSA, rs.id, ed.private), false},
+		{"EdDSA when HS256 is configured", "HS256", signed(jwt.SigningMethodEdDSA, ed.id, ed.private), false},
+		{"unknown kid", "EdDSA", signed(jwt.SigningMethodEdDSA, "unknown", ed.private), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useAlgorithm(t, tt.algorithm)
+			useKeys(t, 0, ed, rs)
+
+			// Keyfunc is called directly, as the JWT library refuses most
+			// mismatched key types on its own.
+			token, _, err := jwt.NewParser().ParseUnverified(tt.token, jwt.MapClaims{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Keyfunc(token); (err == nil) != tt.valid {
+				t.Errorf("Keyfunc error = %v, want valid %v", err, tt.valid)
+			}
+			if _, err := jwt.Parse(tt.token, Keyfunc); (err == nil) != tt.valid {
+				t.Errorf("Parse error = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestSignUsesCurrentKey(t *testing.T) {
+	useAlgorithm(t, "EdDSA")
+	now := time.Now()
+	current := newKey(t, "EdDSA", now, now.Add(time.Hour))
+	useKeys(t, 0, current)
+
+	signed, err := Sign(context.Background(), jwt.MapClaims{"sub": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Parse(signed, Keyfunc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := token.Header["kid"]; kid != current.id {
+		t.Errorf("kid = %v, want %s", kid, current.id)
+	}
+}
+
+func TestCurrentKey(t *testing.T) {
+	useAlgorithm(t, "EdDSA")
+	now := time.Now()
+	older := newKey(t, "EdDSA", now.Add(-48*time.Hour), now.Add(time.Hour))
+	newest := newKey(t, "EdDSA", now.Add(-time.Hour), now.Add(24*time.Hour))
+	retired := newKey(t, "EdDSA", now, now.Add(-time.Minute))
+	otherAlgorithm := newKey(t, "RS256", now, now.Add(24*time.Hour))
+
+	useKeys(t, 0, older, newest, retired, otherAlgorithm)
+	k, err := currentKey(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k != newest {
+		t.Errorf("current key = %s, want the newest unretired %s", k.id, newest.id)
+	}
+
+	useKeys(t, 0, retired, otherAlgorithm)
+	if _, err := currentKey(context.Background()); err == nil {
+		t.Error("retired key used for signing")
+	}
+}
+
+func TestLookupReload(t *testing.T) {
+	useAlgorithm(t, "EdDSA")
+	now := time.Now()
+	known := newKey(t, "EdDSA", now, now.Add(time.Hour))
+
+	tests := []struct {
+		name    string
+		kid     string
+		age     time.Duration
+		reloads int
+	}{
+		{"known key", known.id, 0, 0},
+		{"unknown key just after loading", "unknown", minReload / 2, 0},
+		{"unknown key", "unknown", minReload * 2, 1},
+		{"stale cache", "unknown", cacheTTL * 2, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := useDB(t, 0)
+			useKeys(t, tt.age, known)
+
+			_, err := lookup(context.Background(), tt.kid)
+			if tt.kid == known.id && err != nil {
+				t.Error(err)
+			}
+			if tt.kid != known.id && !errors.Is(err, ErrUnknownKey) {
+				t.Errorf("error = %v, want %v", err, ErrUnknownKey)
+			}
+			if reloads := len(recorder.statements); reloads != tt.reloads {
+				t.Errorf("keys loaded %d times, want %d", reloads, tt.reloads)
+			}
+		})
+	}
+}
+
+func TestLoadSkipsExpiredKeys(t *testing.T) {
+	recorder := useDB(t, 0)
+	useKeys(t, 0)
+
+	mu.Lock()
+	err := load(context.Background())
+	mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.ran(`SELECT * FROM "signing_keys" WHERE expires_at > $1`)) != 1 {
+		t.Errorf("expired keys loaded: %v", recorder.statements)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	useAlgorithm(t, "EdDSA")
+	auth := config.Cfg.Auth
+
+	t.Run("current key", func(t *testing.T) {
+		recorder := useDB(t, 1)
+		useKeys(t, 0)
+
+		if err := Rotate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if len(recorder.ran(`DELETE FROM "signing_keys" WHERE expires_at < $1`)) != 1 {
+			t.Error("expired keys not deleted")
+		}
+		if len(recorder.ran("UPDATE")) > 0 || len(recorder.ran("INSERT")) > 0 {
+			t.Errorf("key rotated while the current one is not about to retire: %v", recorder.statements)
+		}
+	})
+
+	t.Run("no current key", func(t *testing.T) {
+		recorder := useDB(t, 0)
+		useKeys(t, 0)
+
+		before := time.Now()
+		if err := Rotate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		after := time.Now()
+
+		counts := recorder.ran(`SELECT count(*) FROM "signing_keys"`)
+		if len(counts) != 1 {
+			t.Fatalf("current keys not counted: %v", recorder.statements)
+		}
+		// Keys retiring within rotateAhead are replaced already.
+		within(t, "counted keys retiring after", counts[0].args[1], before.Add(rotateAhead), after.Add(rotateAhead))
+
+		// Tokens of the replaced keys stay valid for one token lifetime
+		// after the last instance stops signing with them.
+		updates := recorder.ran(`UPDATE "signing_keys" SET "expires_at"=$1,"retires_at"=$2 WHERE retires_at > $3`)
+		if len(updates) != 1 {
+			t.Fatalf("replaced keys not retired: %v", recorder.statements)
+		}
+		within(t, "replaced keys expire", updates[0].args[0], before.Add(auth.AccessTokenTTL+cacheTTL), after.Add(auth.AccessTokenTTL+cacheTTL))
+		within(t, "replaced keys retire", updates[0].args[1], before, after)
+
+		inserts := recorder.ran(`INSERT INTO "signing_keys"`)
+		if len(inserts) != 1 {
+			t.Fatalf("no key created: %v", recorder.statements)
+		}
+		columns := insertedColumns(inserts[0])
+		if columns["algorithm"] != "EdDSA" {
+			t.Errorf("algorithm = %v, want EdDSA", columns["algorithm"])
+		}
+		retiresAt := before.Add(auth.KeyRotation)
+		within(t, "new key retires", columns["retires_at"], retiresAt, after.Add(auth.KeyRotation))
+		within(t, "new key expires", columns["expires_at"], retiresAt.Add(auth.AccessTokenTTL+cacheTTL), after.Add(auth.KeyRotation+auth.AccessTokenTTL+cacheTTL))
+	})
+}
+
+// within checks that value is a time between from and to.
+func within(t *testing.T, name string, value driver.Value, from, to time.Time) {
+	t.Helper()
+	at, ok := value.(time.Time)
+	if !ok {
+		t.Errorf("%s at %v, want a time", name, value)
+		return
+	}
+	if at.Before(from) || at.After(to) {
+		t.Errorf("%s at %s, want between %s and %s", name, at, from, to)
+	}
+}
+
+// insertedColumns maps the columns of an INSERT statement to their values.
+func insertedColumns(s statement) map[string]driver.Value {
+	_, list, _ := strings.Cut(s.query, "(")
+	list, _, _ = strings.Cut(list, ")")
+
+	columns := map[string]driver.Value{}
+	for i, column := range strings.Split(list, ",") {
+		if i < len(s.args) {
+			columns[strings.Trim(column, `" `)] = s.args[i]
+		}
+	}
+	return columns
+}
@@ -0,0 +1,113 @@
+package sso
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"todo-app/config"
+	"todo-app/tracing"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var ErrUnknownProvider = errors.New("unknown identity provider")
+
+// Provider is a configured OpenID Connect identity provider.
+type Provider struct {
+	Config   config.OIDCProviderConfig
+	OAuth2   oauth2.Config
+	Verifier *oidc.IDTokenVerifier
+}
+
+// Claims are the ID token claims used to find or create the local account.
+type Claims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+	Nonce             string `json:"nonce"`
+}
+
+var (
+	mu        sync.Mutex
+	providers = map[string]*Provider{}
+)
+
+// Get returns the provider called name. Discovery runs on first use so that
+// an unreachable identity provider does not prevent the API from starting.
+func Get(ctx context.Context, name string) (*Provider, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if p, ok := providers[name]; ok {
+		return p, nil
+	}
+
+	cfg, ok := config.Cfg.OIDC.Provider(name)
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	ctx = Context(ctx)
+	discovered, err := oidc.NewProvider(ctx, cfg.IssuerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+
+	p := &Provider{
+		Config: cfg,
+		OAuth2: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     discovered.Endpoint(),
+			Scopes:       scopes,
+		},
+		Verifier: discovered.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+	}
+	providers[name] = p
+
+	return p, nil
+}
+
+// Context makes the OIDC and OAuth2 libraries use the traced HTTP client.
+func Context(ctx context.Context) context.Context {
+	return oidc.ClientContext(ctx, tracing.HTTPClient())
+}
+
+// Exchange trades the authorization code for tokens and returns the verified
+// claims of the ID token.
+func (p *Provider) Exchange(ctx context.Context, code string, verifier string, nonce string) (Claims, error) {
+	var claims Claims
+	ctx = Context(ctx)
+
+	token, err := p.OAuth2.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return claims, err
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return claims, errors.New("token response has no id_token")
+	}
+
+	idToken, err := p.Verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return claims, err
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return claims, err
+	}
+	if claims.Nonce != nonce {
+		return claims, errors.New("id_token nonce does not match")
+	}
+
+	return claims, nil
+}
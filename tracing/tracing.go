@@ -0,0 +1,108 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "todo-app"
+
+// Options selects where spans are exported.
+type Options struct {
+	ServiceName string
+	// Exporter is "otlp" or "stdout".
+	Exporter string
+	// Protocol is "grpc" or "http" and only applies to the otlp exporter.
+	Protocol string
+	// Endpoint is the collector address, e.g. localhost:4317. When empty the
+	// standard OTEL_EXPORTER_OTLP_* environment variables are used.
+	Endpoint    string
+	Insecure    bool
+	SampleRatio float64
+}
+
+// Setup installs the global tracer provider and the W3C trace context
+// propagator. The returned function flushes pending spans and must be called
+// on shutdown.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	exporter, err := newExporter(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(opts.ServiceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	return provider.Shutdown, nil
+}
+
+func newExporter(ctx context.Context, opts Options) (sdktrace.SpanExporter, error) {
+	switch opts.Exporter {
+	case "stdout":
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		if opts.Protocol == "http" {
+			var httpOpts []otlptracehttp.Option
+			if opts.Endpoint != "" {
+				httpOpts = append(httpOpts, otlptracehttp.WithEndpoint(opts.Endpoint))
+			}
+			if opts.Insecure {
+				httpOpts = append(httpOpts, otlptracehttp.WithInsecure())
+			}
+			return otlptracehttp.New(ctx, httpOpts...)
+		}
+
+		var grpcOpts []otlptracegrpc.Option
+		if opts.Endpoint != "" {
+			grpcOpts = append(grpcOpts, otlptracegrpc.WithEndpoint(opts.Endpoint))
+		}
+		if opts.Insecure {
+			grpcOpts = append(grpcOpts, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.New(ctx, grpcOpts...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", opts.Exporter)
+	}
+}
+
+// Tracer returns the tracer used for spans created by this application.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// HTTPClient returns a client that starts a span for each outgoing request
+// and injects the traceparent header, for calls to webhooks and other
+// services.
+func HTTPClient() *http.Client {
+	return &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+}
@@ -0,0 +1,162 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+)
+
+var (
+	ErrTooLarge        = errors.New("file is too large")
+	ErrUnsupportedType = errors.New("file type is not allowed")
+	ErrNoFile          = errors.New("no file uploaded")
+	ErrEmpty           = errors.New("file is empty")
+)
+
+// sniffLen is the number of bytes http.DetectContentType looks at.
+const sniffLen = 512
+
+// File is an uploaded file read into memory.
+type File struct {
+	Filename string
+	// ContentType is detected from the content, never taken from the
+	// client.
+	ContentType string
+	Data        []byte
+	// SHA256 is the hex encoded checksum of Data.
+	SHA256 string
+}
+
+func (f File) Size() int64 {
+	return int64(len(f.Data))
+}
+
+// WithData returns f with its content replaced by data, e.g. after removing
+// metadata, and the checksum updated.
+func (f File) WithData(data []byte) File {
+	sum := sha256.Sum256(data)
+	f.Data = data
+	f.SHA256 = hex.EncodeToString(sum[:])
+	return f
+}
+
+// Read reads r, computing the checksum on the fly and detecting the content
+// type from the first bytes. Reading stops as soon as the detected type is
+// not in allowed or the file exceeds maxSize for its type.
+func Read(r io.Reader, filename string, allowed []string, maxSize func(contentType string) int64) (File, error) {
+	file := File{Filename: filename}
+	hash := sha256.New()
+	var buf bytes.Buffer
+	r = io.TeeReader(r, hash)
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return file, err
+	}
+	if n == 0 {
+		return file, ErrEmpty
+	}
+	head = head[:n]
+
+	file.ContentType, _, _ = mime.ParseMediaType(http.DetectContentType(head))
+	if !slices.Contains(allowed, file.ContentType) {
+		return file, ErrUnsupportedType
+	}
+
+	// One byte past the limit tells an oversized file from one exactly at
+	// the limit.
+	limit := maxSize(file.ContentType)
+	buf.Write(head)
+	if _, err := buf.ReadFrom(io.LimitReader(r, limit+1-int64(n))); err != nil {
+		return file, err
+	}
+	if int64(buf.Len()) > limit {
+		return file, ErrTooLarge
+	}
+
+	file.Data = buf.Bytes()
+	file.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return file, nil
+}
+
+// ReadPart streams the first file in one of the multipart form fields from
+// the request body without buffering the rest of the form.
+func ReadPart(req *http.Request, allowed []string, maxSize func(contentType string) int64, fields ...string) (File, error) {
+	reader, err := req.MultipartReader()
+	if err != nil {
+		return File{}, ErrNoFile
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return File{}, ErrNoFile
+		}
+		if err != nil {
+			return File{}, tooLarge(err)
+		}
+		if !slices.Contains(fields, part.FormName()) || part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		file, err := Read(part, part.FileName(), allowed, maxSize)
+		part.Close()
+		return file, tooLarge(err)
+	}
+}
+
+// EachPart streams every file in one of the multipart form fields from the
+// request body to fn, in order. Files that are rejected by Read are passed to
+// fn with their error and the following files are still read. EachPart stops
+// when fn returns an error, which it returns, or the body cannot be read.
+func EachPart(req *http.Request, allowed []string, maxSize func(contentType string) int64, fn func(File, error) error, fields ...string) error {
+	reader, err := req.MultipartReader()
+	if err != nil {
+		return ErrNoFile
+	}
+
+	found := false
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			if !found {
+				return ErrNoFile
+			}
+			return nil
+		}
+		if err != nil {
+			return tooLarge(err)
+		}
+		if !slices.Contains(fields, part.FormName()) || part.FileName() == "" {
+			part.Close()
+			continue
+		}
+		found = true
+
+		file, err := Read(part, part.FileName(), allowed, maxSize)
+		part.Close()
+		if err != nil && !errors.Is(err, ErrTooLarge) && !errors.Is(err, ErrUnsupportedType) && !errors.Is(err, ErrEmpty) {
+			return tooLarge(err)
+		}
+		if err := fn(file, err); err != nil {
+			return err
+		}
+	}
+}
+
+// tooLarge maps the error of an http.MaxBytesReader around the body to
+// ErrTooLarge.
+func tooLarge(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return ErrTooLarge
+	}
+	return err
+}
@@ -0,0 +1,181 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+var allowed = []string{"image/png", "text/plain"}
+
+// pngHeader starts every PNG file.
+var pngHeader = []byte("\x89PNG\r\n\x1a\n")
+
+func limit(size int64) func(string) int64 {
+	return func(string) int64 { return size }
+}
+
+// png returns PNG content of size bytes.
+func png(size int) []byte {
+	return append(append([]byte{}, pngHeader...), bytes.Repeat([]byte{0}, size-len(pngHeader))...)
+}
+
+func TestRead(t *testing.T) {
+	const maxSize = 1024
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		err         error
+	}{
+		{"at the limit", png(maxSize), "image/png", nil},
+		{"one byte over", png(maxSize + 1), "image/png", ErrTooLarge},
+		{"shorter than the sniffed bytes", png(100), "image/png", nil},
+		{"text", []byte("plain text"), "text/plain", nil},
+		{"not allowed", []byte("%PDF-1.7\n"), "application/pdf", ErrUnsupportedType},
+		{"empty", nil, "", ErrEmpty},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := Read(bytes.NewReader(tt.data), "file", allowed, limit(maxSize))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if file.ContentType != tt.contentType {
+				t.Errorf("content type = %q, want %q", file.ContentType, tt.contentType)
+			}
+			if err != nil {
+				return
+			}
+			if !bytes.Equal(file.Data, tt.data) {
+				t.Errorf("read %d bytes, want %d", len(file.Data), len(tt.data))
+			}
+			sum := sha256.Sum256(tt.data)
+			if file.SHA256 != hex.EncodeToString(sum[:]) {
+				t.Errorf("checksum = %s, want %x", file.SHA256, sum)
+			}
+		})
+	}
+}
+
+func TestReadLimitsByType(t *testing.T) {
+	sizes := func(contentType string) int64 {
+		if contentType == "text/plain" {
+			return 4
+		}
+		return 1024
+	}
+	if _, err := Read(strings.NewReader("plain text"), "notes.txt", allowed, sizes); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("text error = %v, want %v", err, ErrTooLarge)
+	}
+	if _, err := Read(bytes.NewReader(png(100)), "image.png", allowed, sizes); err != nil {
+		t.Errorf("image error = %v", err)
+	}
+}
+
+func TestReadStopsPastTheLimit(t *testing.T) {
+	r := io.MultiReader(bytes.NewReader(png(sniffLen)), endless{})
+	if _, err := Read(r, "image.png", allowed, limit(4096)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("error = %v, want %v", err, ErrTooLarge)
+	}
+}
+
+// endless is a reader that never ends.
+type endless struct{}
+
+func (endless) Read(p []byte) (int, error) { return len(p), nil }
+
+// multipartRequest returns a request with a form holding the file content
+// in field, sent with contentType.
+func multipartRequest(t *testing.T, field, contentType string, content []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	if err := w.WriteField("title", "ignored"); err != nil {
+		t.Fatal(err)
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="`+field+`"; filename="upload.png"`)
+	header.Set("Content-Type", contentType)
+	part, err := w.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/tasks/1/images", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return req
+}
+
+func TestReadPart(t *testing.T) {
+	const maxSize = 1024
+	script := []byte("<html><script>alert(1)</script></html>")
+	tests := []struct {
+		name        string
+		field       string
+		contentType string
+		content     []byte
+		err         error
+	}{
+		{"at the limit", "image", "image/png", png(maxSize), nil},
+		{"one byte over", "image", "image/png", png(maxSize + 1), ErrTooLarge},
+		{"spoofed content type", "image", "image/png", script, ErrUnsupportedType},
+		{"other field", "attachment", "image/png", png(100), ErrNoFile},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := multipartRequest(t, tt.field, tt.contentType, tt.content)
+			file, err := ReadPart(req, allowed, limit(maxSize), "image")
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if file.Filename != "upload.png" || file.ContentType != "image/png" || !bytes.Equal(file.Data, tt.content) {
+				t.Errorf("file = %s of type %s with %d bytes", file.Filename, file.ContentType, len(file.Data))
+			}
+		})
+	}
+}
+
+func TestReadPartSniffsType(t *testing.T) {
+	// The client claims a PNG, but the content is text, which is what the
+	// file is stored as.
+	req := multipartRequest(t, "image", "image/png", []byte("plain text"))
+	file, err := ReadPart(req, allowed, limit(1024), "image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.ContentType != "text/plain" {
+		t.Errorf("content type = %q, want text/plain", file.ContentType)
+	}
+}
+
+func TestReadPartBodyLimit(t *testing.T) {
+	req := multipartRequest(t, "image", "image/png", png(4096))
+	req.Body = http.MaxBytesReader(httptest.NewRecorder(), req.Body, 1024)
+	if _, err := ReadPart(req, allowed, limit(8192), "image"); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("error = %v, want %v", err, ErrTooLarge)
+	}
+}
+
+func TestReadPartWithoutForm(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/api/tasks/1/images", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	if _, err := ReadPart(req, allowed, limit(1024), "image"); !errors.Is(err, ErrNoFile) {
+		t.Errorf("error = %v, want %v", err, ErrNoFile)
+	}
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// ErrUnauthenticated is returned by the claim helpers when the request
+// carries no valid credentials.
+var ErrUnauthenticated = errors.New("request is not authenticated")
+
+// Claims are the claims of an access token. The subject is the user ID.
+type Claims struct {
+	jwt.RegisteredClaims
+	// SessionID is empty for personal access tokens.
+	SessionID string `json:"sid,omitempty"`
+
+	// PersonalAccessToken and Scopes are set by the authentication
+	// middleware for personal access tokens and never appear in a JWT.
+	PersonalAccessToken bool     `json:"-"`
+	Scopes              []string `json:"-"`
+}
+
+// Validate requires the subject to be a user ID. The parser calls it after
+// checking the registered claims.
+func (c Claims) Validate() error {
+	if _, err := c.UserID(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c Claims) UserID() (uint, error) {
+	id, err := strconv.ParseUint(c.Subject, 10, 64)
+	if err != nil || id == 0 {
+		return 0, errors.New("token subject is not a user ID")
+	}
+	return uint(id), nil
+}
+
+// GetClaims returns the claims stored by the authentication middleware.
+func GetClaims(c echo.Context) (*Claims, error) {
+	token, ok := c.Get("user").(*jwt.Token)
+	if !ok || token == nil {
+		return nil, ErrUnauthenticated
+	}
+	claims, ok := token.Claims.(*Claims)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	return claims, nil
+}
+
+// UserID returns the authenticated user.
+func UserID(c echo.Context) (uint, error) {
+	claims, err := GetClaims(c)
+	if err != nil {
+		return 0, err
+	}
+	return claims.UserID()
+}
@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"todo-app/config"
+)
+
+// NewToken returns a random, URL-safe token with 256 bits of entropy.
+func NewToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the HMAC of token under the configured token secret. Only
+// this value is stored, so a database leak does not expose usable tokens.
+func HashToken(token string) string {
+	mac := hmac.New(sha256.New, []byte(config.Cfg.Auth.TokenSecret))
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
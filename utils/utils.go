@@ -1,18 +1,31 @@
 package utils
 
 import (
+	"log/slog"
+	"math"
+	"net/http"
 	"strconv"
+	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 )
 
+// GetUserID returns the authenticated user, or 0 when there is none. It is
+// meant for handlers behind the authentication middleware; use UserID where
+// the request may be unauthenticated.
 func GetUserID(c echo.Context) uint {
-	token := c.Get("user").(*jwt.Token)
-	claims := token.Claims.(jwt.MapClaims)
-	userID := claims["user_id"].(float64)
+	userID, _ := UserID(c)
+	return userID
+}
 
-	return uint(userID)
+// GetSessionID returns the session of the access token, or "" for personal
+// access tokens.
+func GetSessionID(c echo.Context) string {
+	claims, err := GetClaims(c)
+	if err != nil {
+		return ""
+	}
+	return claims.SessionID
 }
 
 func GetTaskID(c echo.Context) (uint, error) {
@@ -25,3 +38,26 @@ func GetTaskID(c echo.Context) (uint, error) {
 
 	return uint(taskID), nil
 }
+
+// InternalServerError logs err together with the request context and answers
+// with a generic message, so that the cause never reaches the client.
+func InternalServerError(c echo.Context, message string, err error) error {
+	slog.ErrorContext(c.Request().Context(), message, "error", err)
+
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"message": message,
+	})
+}
+
+// TooManyRequests answers with 429 and a Retry-After header in whole seconds.
+func TooManyRequests(c echo.Context, retryAfter time.Duration, message string) error {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Response().Header().Set(echo.HeaderRetryAfter, strconv.Itoa(seconds))
+
+	return c.JSON(http.StatusTooManyRequests, map[string]string{
+		"message": message,
+	})
+}